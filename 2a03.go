@@ -4,6 +4,7 @@ type apu struct {
 	S1, S2 square
 	triangle
 	noise
+	dmc
 
 	Odd        bool
 	FC         byte
@@ -12,6 +13,33 @@ type apu struct {
 	Interrupt  bool
 }
 
+// dmc is the delta modulation channel. It plays 1-bit deltas from sample
+// bytes it reads from memory, stealing CPU cycles to do so.
+type dmc struct {
+	timer
+	IRQ  bool
+	Loop bool
+	// Output is the 7-bit output level.
+	Output byte
+	// SampleAddr and SampleLen are the start and length of the sample.
+	SampleAddr uint16
+	SampleLen  uint16
+	// Addr and Remaining are the next byte to read and how many are left.
+	Addr      uint16
+	Remaining uint16
+	Buffer    byte
+	Full      bool // Buffer holds a byte
+	Shift     byte
+	Bits      byte // bits left in Shift
+	Silence   bool
+	Interrupt bool
+	// Stall is the number of CPU cycles taken by reads. The CPU should
+	// spend them and set Stall to 0.
+	Stall int
+
+	read func(uint16) byte
+}
+
 type noise struct {
 	envelope
 	timer
@@ -84,6 +112,8 @@ type length struct {
 
 func (a *apu) Init() {
 	a.S1.sweep.NegOffset = -1
+	a.dmc.Bits = 8
+	a.dmc.Silence = true
 	for i := uint16(0x4000); i <= 0x400f; i++ {
 		a.Write(i, 0)
 	}
@@ -126,16 +156,26 @@ func (a *apu) Write(v uint16, b byte) {
 		a.noise.Control2(b)
 	case 0x0f:
 		a.noise.Control3(b)
+	case 0x10:
+		a.dmc.Control1(b)
+	case 0x11:
+		a.dmc.Control2(b)
+	case 0x12:
+		a.dmc.Control3(b)
+	case 0x13:
+		a.dmc.Control4(b)
 	case 0x15:
 		a.S1.Disable(b&0x1 == 0)
 		a.S2.Disable(b&0x2 == 0)
 		a.triangle.Disable(b&0x4 == 0)
 		a.noise.Disable(b&0x8 == 0)
+		a.dmc.Disable(b&0x10 == 0)
 	case 0x17:
 		a.FT = 0
 		if b&0x80 != 0 {
 			a.FC = 5
-			a.FrameStep()
+			a.quarterFrame()
+			a.halfFrame()
 		} else {
 			a.FC = 4
 		}
@@ -152,13 +192,34 @@ func (n *noise) Control1(b byte) {
 
 func (n *noise) Control2(b byte) {
 	n.timer.length = noiseLookup[b&0xf]
-	n.Short = b&0x8 != 0
+	n.Short = b&0x80 != 0
 }
 
 func (n *noise) Control3(b byte) {
 	n.length.Set(b >> 3)
 }
 
+func (d *dmc) Control1(b byte) {
+	d.IRQ = b&0x80 != 0
+	d.Loop = b&0x40 != 0
+	d.timer.length = dmcLookup[b&0xf] - 1
+	if !d.IRQ {
+		d.Interrupt = false
+	}
+}
+
+func (d *dmc) Control2(b byte) {
+	d.Output = b & 0x7f
+}
+
+func (d *dmc) Control3(b byte) {
+	d.SampleAddr = 0xc000 | uint16(b)<<6
+}
+
+func (d *dmc) Control4(b byte) {
+	d.SampleLen = uint16(b)<<4 | 1
+}
+
 func (t *triangle) Control1(b byte) {
 	t.linear.Control(b)
 	t.length.Halt = b&0x80 != 0
@@ -252,6 +313,22 @@ func (n *noise) Disable(b bool) {
 	}
 }
 
+// Disable stops the sample if b, or else starts it if it has ended. Either
+// clears the interrupt.
+func (d *dmc) Disable(b bool) {
+	d.Interrupt = false
+	if b {
+		d.Remaining = 0
+	} else if d.Remaining == 0 {
+		d.restart()
+	}
+}
+
+func (d *dmc) restart() {
+	d.Addr = d.SampleAddr
+	d.Remaining = d.SampleLen
+}
+
 func (a *apu) Read(v uint16) byte {
 	var b byte
 	if v == 0x4015 {
@@ -267,6 +344,12 @@ func (a *apu) Read(v uint16) byte {
 		if a.noise.length.Counter > 0 {
 			b |= 0x8
 		}
+		if a.dmc.Remaining > 0 {
+			b |= 0x10
+		}
+		if a.dmc.Interrupt {
+			b |= 0x80
+		}
 		if a.Interrupt {
 			b |= 0x40
 			a.Interrupt = false
@@ -342,16 +425,57 @@ func (t *triangle) Clock() {
 
 func (n *noise) Clock() {
 	if n.timer.Clock() {
-		var feedback uint16
+		// Feedback is bit 0 xor bit 1, or bit 6 in short mode, into bit 14.
+		tap := uint(1)
 		if n.Short {
-			feedback = n.Shift & 0x40 << 8
-		} else {
-			feedback = n.Shift << 13
+			tap = 6
+		}
+		feedback := (n.Shift ^ n.Shift>>tap) & 1
+		n.Shift = n.Shift>>1 | feedback<<14
+	}
+}
+
+// Clock fills the sample buffer if it is empty and, at the end of each timer
+// period, applies the next bit of the shift register to the output.
+func (d *dmc) Clock() {
+	if !d.Full && d.Remaining > 0 && d.read != nil {
+		d.Buffer = d.read(d.Addr)
+		d.Full = true
+		d.Stall += 4
+		d.Addr++
+		if d.Addr == 0 {
+			d.Addr = 0x8000
+		}
+		d.Remaining--
+		if d.Remaining == 0 {
+			if d.Loop {
+				d.restart()
+			} else if d.IRQ {
+				d.Interrupt = true
+			}
+		}
+	}
+	if !d.timer.Clock() {
+		return
+	}
+	if !d.Silence {
+		if d.Shift&1 != 0 {
+			if d.Output <= 125 {
+				d.Output += 2
+			}
+		} else if d.Output >= 2 {
+			d.Output -= 2
 		}
-		feedback ^= n.Shift << 14
-		n.Shift >>= 1
-		n.Shift &= 0x3fff
-		n.Shift |= feedback
+	}
+	d.Shift >>= 1
+	if d.Bits > 0 {
+		d.Bits--
+	}
+	if d.Bits == 0 {
+		d.Bits = 8
+		d.Silence = !d.Full
+		d.Shift = d.Buffer
+		d.Full = false
 	}
 }
 
@@ -371,30 +495,44 @@ func (a *apu) Step() {
 	if a.triangle.Enable {
 		a.triangle.Clock()
 	}
+	a.dmc.Clock()
 }
 
+// FrameStep runs the next step of the frame sequencer. In 4-step mode each
+// step clocks the envelopes and linear counter, steps 2 and 4 also clock the
+// length counters and sweeps, and step 4 raises the IRQ. 5-step mode is the
+// same but for no clocks at step 4, no IRQ, and the half frame at step 5.
 func (a *apu) FrameStep() {
 	a.FT++
+	step := a.FT
 	if a.FT == a.FC {
 		a.FT = 0
 	}
-	if a.FT <= 3 {
-		a.S1.envelope.Clock()
-		a.S2.envelope.Clock()
-		a.triangle.linear.Clock()
-		a.noise.envelope.Clock()
+	if step != 4 || a.FC == 4 {
+		a.quarterFrame()
 	}
-	if a.FT == 1 || a.FT == 3 {
-		a.S1.FrameStep()
-		a.S2.FrameStep()
-		a.triangle.length.Clock()
-		a.noise.length.Clock()
+	if step == 2 || step == a.FC {
+		a.halfFrame()
 	}
-	if a.FC == 4 && a.FT == 3 && !a.IrqDisable {
+	if a.FC == 4 && step == 4 && !a.IrqDisable {
 		a.Interrupt = true
 	}
 }
 
+func (a *apu) quarterFrame() {
+	a.S1.envelope.Clock()
+	a.S2.envelope.Clock()
+	a.triangle.linear.Clock()
+	a.noise.envelope.Clock()
+}
+
+func (a *apu) halfFrame() {
+	a.S1.FrameStep()
+	a.S2.FrameStep()
+	a.triangle.length.Clock()
+	a.noise.length.Clock()
+}
+
 func (l *linear) Clock() {
 	if l.Halt {
 		l.Counter = l.Reload
@@ -411,7 +549,7 @@ func (s *square) FrameStep() {
 	if s.sweep.Clock() && s.sweep.Enable && s.sweep.Shift > 0 {
 		r := s.SweepResult()
 		if r <= 0x7ff {
-			s.timer.Tick = r
+			s.timer.length = r
 		}
 	}
 }
@@ -424,12 +562,12 @@ func (l *length) Clock() {
 
 func (a *apu) Volume() float32 {
 	p := pulseOut[a.S1.Volume()+a.S2.Volume()]
-	t := tndOut[3*a.triangle.Volume()+2*a.noise.Volume()]
+	t := tndOut[3*int(a.triangle.Volume())+2*int(a.noise.Volume())+int(a.dmc.Output)]
 	return p + t
 }
 
 func (n *noise) Volume() uint8 {
-	if n.Enable && n.length.Counter > 0 && n.Shift&0x1 != 0 {
+	if n.Enable && n.length.Counter > 0 && n.Shift&0x1 == 0 {
 		return n.envelope.Output()
 	}
 	return 0
@@ -443,7 +581,7 @@ func (t *triangle) Volume() uint8 {
 }
 
 func (s *square) Volume() uint8 {
-	if s.Enable && s.duty.Enabled() && s.length.Enabled() && s.timer.Tick >= 8 && s.SweepResult() <= 0x7ff {
+	if s.Enable && s.duty.Enabled() && s.length.Enabled() && s.timer.length >= 8 && s.SweepResult() <= 0x7ff {
 		return s.envelope.Output()
 	}
 	return 0
@@ -456,14 +594,19 @@ func (e *envelope) Output() byte {
 	return e.Counter
 }
 
+// SweepResult returns the period the sweep unit would set. The first pulse
+// channel negates in ones' complement, so its NegOffset is -1.
 func (s *square) SweepResult() uint16 {
-	r := int(s.timer.Tick >> s.sweep.Shift)
+	r := int(s.timer.length >> s.sweep.Shift)
 	if s.sweep.Negate {
-		r = -r
+		r = -r + s.sweep.NegOffset
 	}
-	r += int(s.timer.Tick)
+	r += int(s.timer.length)
 	if r > 0x7ff {
 		r = 0x800
+	} else if r < 0 {
+		// Pulse 1 negating a shift of 0. Negating never mutes.
+		r = 0
 	}
 	return uint16(r)
 }
@@ -501,6 +644,11 @@ var (
 		0x8, 0x9, 0xA, 0xB,
 		0xC, 0xD, 0xE, 0xF,
 	}
+	// dmcLookup is the NTSC DMC timer period, in CPU cycles, of each rate.
+	dmcLookup = [...]uint16{
+		428, 380, 340, 320, 286, 254, 226, 214,
+		190, 160, 142, 128, 106, 84, 72, 54,
+	}
 	noiseLookup = [...]uint16{
 		0x004, 0x008, 0x010, 0x020,
 		0x040, 0x060, 0x080, 0x0a0,
@@ -0,0 +1,247 @@
+package nsf
+
+import "testing"
+
+func newAPU() *apu {
+	a := new(apu)
+	a.Init()
+	return a
+}
+
+func TestSquarePeriod(t *testing.T) {
+	a := newAPU()
+	a.Write(0x4002, 0x34)
+	a.Write(0x4003, 0x02)
+	if p := a.S1.timer.length; p != 0x234 {
+		t.Fatalf("got period %03X, expected 234", p)
+	}
+	a.Write(0x4006, 0x10)
+	a.Write(0x4007, 0x00)
+	if p := a.S2.timer.length; p != 0x10 {
+		t.Fatalf("got period %03X, expected 010", p)
+	}
+	// The duty sequencer steps once every period+1 timer clocks.
+	steps := 0
+	for i := 0; i < 0x11*8; i++ {
+		d := a.S2.duty.Counter
+		a.S2.Clock()
+		if a.S2.duty.Counter != d {
+			steps++
+		}
+	}
+	if steps != 8 {
+		t.Errorf("got %d duty steps in 8 periods, expected 8", steps)
+	}
+}
+
+func TestSquareSweep(t *testing.T) {
+	a := newAPU()
+	a.Write(0x4002, 0x00)
+	a.Write(0x4003, 0x01)
+	a.Write(0x4006, 0x00)
+	a.Write(0x4007, 0x01)
+	// Enabled, period 0, negate, shift 1.
+	a.Write(0x4001, 0x89)
+	a.Write(0x4005, 0x89)
+	// The first pulse channel subtracts one more.
+	if r := a.S1.SweepResult(); r != 0x7f {
+		t.Errorf("pulse 1: got %03X, expected 07F", r)
+	}
+	if r := a.S2.SweepResult(); r != 0x80 {
+		t.Errorf("pulse 2: got %03X, expected 080", r)
+	}
+	// Pulse 1 negating with shift 0 goes below 0, which doesn't mute.
+	a.Write(0x4001, 0x08)
+	if r := a.S1.SweepResult(); r != 0 {
+		t.Errorf("pulse 1 shift 0: got %03X, expected 000", r)
+	}
+	// The sweep changes the period, not the current count.
+	a.S2.timer.Tick = 0x20
+	a.S2.FrameStep()
+	if p := a.S2.timer.length; p != 0x80 {
+		t.Errorf("got period %03X after a sweep, expected 080", p)
+	}
+	// A period under 8 mutes the channel, whatever the count.
+	a.Write(0x4015, 0x02)
+	a.Write(0x4004, 0x1f)
+	a.Write(0x4005, 0x00)
+	a.Write(0x4006, 0x07)
+	a.Write(0x4007, 0x08)
+	a.S2.timer.Tick = 0x100
+	a.S2.duty.Type, a.S2.duty.Counter = 3, 0
+	if v := a.S2.Volume(); v != 0 {
+		t.Errorf("got volume %d with period 7, expected 0", v)
+	}
+	a.Write(0x4006, 0x08)
+	if v := a.S2.Volume(); v != 15 {
+		t.Errorf("got volume %d with period 8, expected 15", v)
+	}
+}
+
+func TestTriangleSequence(t *testing.T) {
+	a := newAPU()
+	a.Write(0x4015, 0x04)
+	a.Write(0x4008, 0x7f)
+	a.Write(0x400a, 0x02)
+	a.Write(0x400b, 0x08)
+	a.triangle.linear.Clock()
+	if a.triangle.linear.Counter != 0x7f || a.triangle.length.Counter == 0 {
+		t.Fatalf("counters not loaded: linear %d, length %d", a.triangle.linear.Counter, a.triangle.length.Counter)
+	}
+	// The first clock reloads the timer and steps; then every 3 clocks.
+	var got []byte
+	for i := 0; i < 1+3*32; i++ {
+		a.Step()
+		if i%3 == 0 {
+			got = append(got, a.triangle.Volume())
+		}
+	}
+	for i, v := range got {
+		if e := triLookup[(i+1)%32]; v != e {
+			t.Fatalf("step %d: got %d, expected %d", i, v, e)
+		}
+	}
+	if a.triangle.SI != 1 {
+		t.Errorf("SI is %d after 33 steps, expected 1", a.triangle.SI)
+	}
+	// Without the linear counter, the sequence holds.
+	a.triangle.linear.Counter = 0
+	for i := 0; i < 30; i++ {
+		a.Step()
+	}
+	if a.triangle.SI != 1 {
+		t.Errorf("SI moved to %d with the linear counter at 0", a.triangle.SI)
+	}
+}
+
+// noisePeriod returns the number of shifts before the noise shift register
+// repeats.
+func noisePeriod(n *noise) int {
+	start := n.Shift
+	for i := 1; i <= 1<<15; i++ {
+		n.Clock()
+		if n.Shift == start {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestNoiseLFSR(t *testing.T) {
+	a := newAPU()
+	a.Write(0x400e, 0x00)
+	if a.noise.Short || a.noise.timer.length != noiseLookup[0] {
+		t.Fatal("bad mode or period")
+	}
+	// With period 0 the register shifts on every clock.
+	a.noise.timer.length = 0
+	if p := noisePeriod(&a.noise); p != 32767 {
+		t.Errorf("normal mode: got period %d, expected 32767", p)
+	}
+	a.Write(0x400e, 0x80)
+	a.noise.timer.length = 0
+	if !a.noise.Short {
+		t.Fatal("short mode not set")
+	}
+	if p := noisePeriod(&a.noise); p != 93 {
+		t.Errorf("short mode: got period %d, expected 93", p)
+	}
+	if a.noise.Shift == 0 || a.noise.Shift > 0x7fff {
+		t.Errorf("bad shift register %04X", a.noise.Shift)
+	}
+}
+
+func TestFrameSequencer(t *testing.T) {
+	a := newAPU()
+	a.Write(0x4015, 0x01)
+	// Envelope decay with divider period 0, and a long length.
+	a.Write(0x4000, 0x00)
+	a.Write(0x4003, 0x08)
+	a.Write(0x4017, 0x00)
+	a.S1.envelope.Clock() // start the envelope at 15
+	type counts struct {
+		envelope, length byte
+		irq              bool
+	}
+	var got []counts
+	for i := 0; i < 8; i++ {
+		a.FrameStep()
+		got = append(got, counts{a.S1.envelope.Counter, a.S1.length.Counter, a.Interrupt})
+	}
+	len0 := lenLookup[1]
+	expect := []counts{
+		{14, len0, false},
+		{13, len0 - 1, false},
+		{12, len0 - 1, false},
+		{11, len0 - 2, true},
+		{10, len0 - 2, true},
+		{9, len0 - 3, true},
+		{8, len0 - 3, true},
+		{7, len0 - 4, true},
+	}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Errorf("step %d: got %+v, expected %+v", i+1, got[i], expect[i])
+		}
+	}
+	// 5-step mode clocks both at once when written, then has no IRQ.
+	a.Read(0x4015)
+	a.Write(0x4017, 0x80)
+	if e, l := a.S1.envelope.Counter, a.S1.length.Counter; e != 6 || l != len0-5 {
+		t.Errorf("5-step write: got envelope %d length %d", e, l)
+	}
+	for i := 0; i < 5; i++ {
+		a.FrameStep()
+	}
+	if e, l := a.S1.envelope.Counter, a.S1.length.Counter; e != 2 || l != len0-7 || a.Interrupt {
+		t.Errorf("5-step: got envelope %d length %d irq %v", e, l, a.Interrupt)
+	}
+}
+
+func TestDMC(t *testing.T) {
+	a := newAPU()
+	var mem [0x10000]byte
+	for i := 0; i < 17; i++ {
+		mem[0xc040+i] = 0xff
+	}
+	var reads []uint16
+	a.dmc.read = func(v uint16) byte {
+		reads = append(reads, v)
+		return mem[v]
+	}
+	a.Write(0x4010, 0x8f) // IRQ, rate 54 cycles
+	a.Write(0x4011, 0x40)
+	a.Write(0x4012, 0x01) // 0xC040
+	a.Write(0x4013, 0x01) // 17 bytes
+	a.Write(0x4015, 0x10)
+	a.Step()
+	if len(reads) != 1 || reads[0] != 0xc040 || a.dmc.Stall != 4 || a.dmc.Remaining != 16 {
+		t.Fatalf("first read: %04X, stall %d, %d left", reads, a.dmc.Stall, a.dmc.Remaining)
+	}
+	// 8 silent bits, then 8 set bits raise the output by 2 each.
+	for i := 1; i < 1+15*54; i++ {
+		a.Step()
+	}
+	if a.dmc.Output != 0x50 {
+		t.Errorf("got output %02X, expected 50", a.dmc.Output)
+	}
+	if b := a.Read(0x4015); b&0x90 != 0x10 {
+		t.Errorf("got status %02X while playing", b)
+	}
+	for i := 0; i < 17*8*54; i++ {
+		a.Step()
+	}
+	if len(reads) != 17 || reads[16] != 0xc050 || a.dmc.Remaining != 0 {
+		t.Errorf("got %d reads, last %04X", len(reads), reads[len(reads)-1])
+	}
+	if a.dmc.Output != 0x7e {
+		t.Errorf("got output %02X, expected 7E", a.dmc.Output)
+	}
+	if b := a.Read(0x4015); b&0x90 != 0x80 {
+		t.Errorf("got status %02X after the sample, expected the IRQ", b)
+	}
+	a.Write(0x4015, 0x00)
+	if a.dmc.Interrupt {
+		t.Error("IRQ not cleared by writing $4015")
+	}
+}
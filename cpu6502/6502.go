@@ -18,36 +18,85 @@
 package cpu6502
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"runtime"
 	"strings"
 )
 
-type timing map[Mode]int
+// timing holds the base cycles of an instruction in each of its modes. If
+// cross is set, a read in an indexed mode that crosses a page takes one more.
+type timing struct {
+	cycles map[Mode]int
+	cross  bool
+}
 
 type Instruction struct {
+	// Name is the mnemonic, which the assembler and disassembler use.
+	Name            string
 	F               Func
 	Imm             byte
 	ZP, ZPX, ZPY    byte
 	ABS, ABSX, ABSY byte
 	IND, INDX, INDY byte
-	SNGL, BRA       byte
+	IMP, ACC, BRA   byte
 	TIM             timing
 }
 
 var Optable [0xff + 1]*Op
 
+// gatedOps holds, for each illegal opcode, the NOP it runs as without
+// AllowIllegal. They are made once by init so Step does not allocate.
+var gatedOps [0xff + 1]*Op
+
 type Func func(*Cpu, byte, uint16, Mode)
 
 type Op struct {
 	Mode
 	F Func
 	T int
+
+	filler bool // an unimplemented opcode run as a NOP
+	cross  bool // indexed reads that cross a page take a cycle more
+	// illegal is set for an unofficial opcode, which is run only if the
+	// Cpu has AllowIllegal set.
+	illegal bool
+	name    string // mnemonic, set from F by init and Override
+}
+
+// each calls f with each mode of i and its opcode, which may be null.
+func (i Instruction) each(f func(m Mode, v byte)) {
+	f(MODE_IMM, i.Imm)
+	f(MODE_ZP, i.ZP)
+	f(MODE_ZPX, i.ZPX)
+	f(MODE_ZPY, i.ZPY)
+	f(MODE_ABS, i.ABS)
+	f(MODE_ABSX, i.ABSX)
+	f(MODE_ABSY, i.ABSY)
+	f(MODE_IND, i.IND)
+	f(MODE_INDX, i.INDX)
+	f(MODE_INDY, i.INDY)
+	f(MODE_IMP, i.IMP)
+	f(MODE_ACC, i.ACC)
+	f(MODE_BRA, i.BRA)
+}
+
+// Length returns the length in bytes of o with its operand. BRK is 2 for its
+// padding byte.
+func (o *Op) Length() int {
+	return o.Mode.size()
 }
 
 func (o *Op) String() string {
-	n := runtime.FuncForPC(reflect.ValueOf(o.F).Pointer()).Name()
+	if o.name != "" {
+		return o.name
+	}
+	return funcName(o.F)
+}
+
+func funcName(f Func) string {
+	n := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
 	n = n[strings.LastIndex(n, ".")+1:]
 	return n
 }
@@ -76,6 +125,8 @@ func (m Mode) Format() string {
 		return "($%02[3]X,X)"
 	case MODE_INDY:
 		return "($%02[3]X),Y"
+	case MODE_ACC:
+		return "A"
 	case MODE_BRA:
 		return "$%02[1]X"
 	default:
@@ -83,6 +134,27 @@ func (m Mode) Format() string {
 	}
 }
 
+var modeNames = [...]string{"IMM", "ZP", "ZPX", "ZPY", "ABS", "ABSX", "ABSY", "IND", "INDX", "INDY", "IMP", "ACC", "BRA"}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modeNames) {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modeNames[m]
+}
+
+// size returns the length in bytes of an instruction in mode m.
+func (m Mode) size() int {
+	switch m {
+	case MODE_IMP, MODE_ACC:
+		return 1
+	case MODE_ABS, MODE_ABSX, MODE_ABSY, MODE_IND:
+		return 3
+	default:
+		return 2
+	}
+}
+
 const (
 	MODE_IMM Mode = iota
 	MODE_ZP
@@ -94,7 +166,8 @@ const (
 	MODE_IND
 	MODE_INDX
 	MODE_INDY
-	MODE_SNGL
+	MODE_IMP
+	MODE_ACC
 	MODE_BRA
 
 	IRQ   = 0xfffe
@@ -102,36 +175,136 @@ const (
 	NMI   = 0xfffa
 )
 
+// CPU clock rates, in Hz, of the NTSC and PAL NES.
+const (
+	NTSCClock = 1789773
+	PALClock  = 1662607
+)
+
+// CyclesPerFrame returns the CPU cycles in one video frame, rounded down: the
+// PPU draws 341*262 dots less one on odd frames at 3 per cycle in NTSC, and
+// 341*312 at 3.2 per cycle in PAL.
+func (c *Cpu) CyclesPerFrame(pal bool) int {
+	if pal {
+		return 341 * 312 * 10 / 32
+	}
+	return (341*262*2 - 1) / 6
+}
+
+// ErrTrap is returned by Run when PC reaches the address set by SetTrapPC.
+var ErrTrap = errors.New("cpu6502: trap")
+
 type Memory interface {
 	Read(uint16) byte
 	Write(uint16, byte)
 }
 
+// RAM is a Memory of plain bytes.
+type RAM [0xffff + 1]byte
+
+func (r *RAM) Read(v uint16) byte     { return r[v] }
+func (r *RAM) Write(v uint16, b byte) { r[v] = b }
+
 type Ticker interface {
 	Tick()
 }
 
+// Device is a peripheral advanced after each instruction by the number of
+// cycles it took.
+type Device interface {
+	Tick(cycles uint64)
+}
+
 type Cpu struct {
 	Register
 	M Memory
+	// T, if non nil, is ticked once for every cycle as it is charged, so it
+	// can keep a cycle-accurate clock.
 	T Ticker
-
+	// Dev, if non nil, is ticked once after each instruction or interrupt
+	// with the total cycles it took. It suits peripherals that only need to
+	// catch up at instruction boundaries.
+	Dev Device
+
+	// DecimalEnabled makes ADC and SBC do BCD arithmetic when the D flag
+	// is set, as a full 6502 does. It is off by default, matching the NES
+	// 2A03, which has no decimal mode.
+	DecimalEnabled bool
+	// DisableDecimal turns decimal mode off even with DecimalEnabled set.
+	//
+	// Deprecated: decimal mode is off unless DecimalEnabled is set.
 	DisableDecimal bool
+	// AllowIllegal enables the unofficial opcodes in Unofficial. Without it
+	// they run as NOPs, as unimplemented opcodes do. The unofficial NOPs
+	// only read and always run.
+	AllowIllegal bool
+	// HaltOnBRK makes BRK set PC to 0, where Run stops, instead of pushing
+	// PC and P and jumping through the IRQ vector. It suits small test
+	// programs that end with BRK.
+	HaltOnBRK bool
+	// DisableJMPBug makes JMP ($xxFF) read its high byte from the next page,
+	// as the 65C02 does, instead of from $xx00.
+	DisableJMPBug bool
+	// Cycles is the number of cycles run.
+	Cycles uint64
 
 	// If non nil, will record registers on each step.
-	L     []Log
-	LI    int // Log index
+	L  []Log
+	LI int // Log index
+	// If set, prints each instruction to stdout. Trace with TextTrace is the
+	// same but to any io.Writer.
 	Debug bool
+	// If non nil, Trace is called with the Log of each instruction.
+	Trace func(Log)
+	// If set, records the address of each executed opcode. See ExecutedMap.
+	Coverage bool
+	// If non nil, SMC is called with the address when an instruction writes
+	// to an executed opcode, or an opcode is executed from a written address.
+	SMC func(addr uint16)
+
+	stepCycles  int
+	executed    []bool
+	written     []bool // addresses written while SMC is set
+	trap        uint16
+	hasTrap     bool
+	breakpoints map[uint16]bool
+	watches     map[uint16][]func(addr uint16, old, new byte)
+	irq         bool // IRQ line asserted
+	nmi         bool // NMI pending
+	// ops, if non nil, replaces Optable for this Cpu.
+	ops *[0xff + 1]*Op
+	// recording, if set, appends external events to events.
+	recording bool
+	events    []Event
+	replay    []Event
+}
+
+// EnableTraceBuffer makes c keep the Logs of the last n instructions in L. An
+// n of 0 stops it.
+func (c *Cpu) EnableTraceBuffer(n int) {
+	c.L, c.LI = nil, 0
+	if n > 0 {
+		c.L = make([]Log, n)
+	}
+}
 
-	stepCycles int
+// TraceLog returns the TraceEntries kept in L, oldest first.
+func (c *Cpu) TraceLog() []TraceEntry {
+	var logs []TraceEntry
+	for i := range c.L {
+		// Entries not yet written have no Op.
+		if l := c.L[(c.LI+i)%len(c.L)]; l.O != nil {
+			logs = append(logs, l)
+		}
+	}
+	return logs
 }
 
+// StringLog returns TraceLog with one instruction per line.
 func (c *Cpu) StringLog() string {
 	s := ""
-	o := c.LI
-	for i := range c.L {
-		li := (i + o) % len(c.L)
-		s += fmt.Sprintf("\n%v", c.L[li])
+	for _, l := range c.TraceLog() {
+		s += fmt.Sprintf("\n%v", l)
 	}
 	return s
 }
@@ -150,32 +323,221 @@ type Log struct {
 	B    byte
 }
 
-func (l Log) String() string {
+// TraceEntry is the record of one executed instruction kept by
+// EnableTraceBuffer.
+type TraceEntry = Log
+
+// operand returns the operand of l in assembler syntax.
+func (l Log) operand() string {
 	m := l.O.Mode.Format()
-	if m != "" {
+	if strings.Contains(m, "%") {
 		m = fmt.Sprintf(m, l.B, l.V, l.T)
 	}
-	return fmt.Sprintf("%04X: %02X %3v %-8s p=%08b s=%02X a=%02X x=%02X y=%02X v=%04X b=%02X t=%04X c=%d", l.R.PC, l.I, l.O, m, l.R.P, l.R.S, l.R.A, l.R.X, l.R.Y, l.V, l.B, l.T, l.C)
+	return m
+}
+
+func (l Log) String() string {
+	return fmt.Sprintf("%04X: %02X %3v %-8s p=%08b s=%02X a=%02X x=%02X y=%02X v=%04X b=%02X t=%04X c=%d", l.R.PC, l.I, l.O, l.operand(), l.R.P, l.R.S, l.R.A, l.R.X, l.R.Y, l.V, l.B, l.T, l.C)
 }
 
+// New returns a Cpu using m with PC at 0. Set PC, or call Reset to start at
+// the reset vector.
 func New(m Memory) *Cpu {
+	return NewAt(m, 0)
+}
+
+// NewAt returns a Cpu using m with PC at pc.
+func NewAt(m Memory, pc uint16) *Cpu {
 	c := Cpu{
 		Register: Register{
-			S: 0xff,
-			P: P_B | P_X | P_I,
+			S:  0xff,
+			P:  P_X | P_I,
+			PC: pc,
 		},
 		M: m,
 	}
 	return &c
 }
 
-func (c *Cpu) Run() {
+// ErrProgramSize is returned by LoadProgram when a program would pass 0xFFFF.
+var ErrProgramSize = errors.New("cpu6502: program passes 0xFFFF")
+
+// LoadProgram copies code into memory at addr and sets PC to addr. If code
+// does not fit below 0x10000, nothing is written and ErrProgramSize is
+// returned.
+func (c *Cpu) LoadProgram(addr uint16, code []byte) error {
+	if int(addr)+len(code) > 0x10000 {
+		return ErrProgramSize
+	}
+	for i, b := range code {
+		c.M.Write(addr+uint16(i), b)
+	}
+	c.PC = addr
+	return nil
+}
+
+// Call pushes the return address 0xFFFF and sets PC to addr, as if a JSR at
+// 0xFFFD jumped there. The routine's RTS then returns to 0, where Run stops.
+func (c *Cpu) Call(addr uint16) {
+	c.push16(0xffff)
+	c.PC = addr
+}
+
+// RunUntilRTS runs the routine at PC until it returns with RTS, using Call's
+// return address. PC is then 0.
+func (c *Cpu) RunUntilRTS() error {
+	c.Call(c.PC)
+	return c.Run()
+}
+
+// ExecutedMap returns, for each address, whether an opcode there has been
+// executed since Coverage or SMC was set. It is nil if neither was ever set.
+func (c *Cpu) ExecutedMap() []bool {
+	return c.executed
+}
+
+// Run steps until PC is 0. It returns ErrTrap if PC reaches the trap address,
+// or the error from Step.
+func (c *Cpu) Run() error {
 	for c.PC != 0 {
-		c.Step()
+		if c.hasTrap && c.PC == c.trap {
+			return ErrTrap
+		}
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunUntil steps until Cycles is at least cycles or, as with Run, PC is 0.
+// It stops early if Step returns an error.
+func (c *Cpu) RunUntil(cycles uint64) error {
+	for c.Cycles < cycles && c.PC != 0 {
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunCycles runs whole instructions while they fit in n cycles or until, as
+// with Run, PC is 0. An instruction is run only if the most it can take, with
+// a page cross or taken branch, fits in what is left, so the cycles returned
+// do not pass n. The exception is the first instruction, which is always run
+// so that repeated calls with a small n progress; callers should take any
+// excess from their next budget. It stops early if Step returns an error.
+func (c *Cpu) RunCycles(n int) (executed int, err error) {
+	for first := true; c.PC != 0; first = false {
+		if !first && c.maxCycles() > n-executed {
+			break
+		}
+		err = c.Step()
+		executed += c.stepCycles
+		if err != nil {
+			break
+		}
 	}
+	return executed, err
+}
+
+// maxCycles returns the most cycles the next Step can take.
+func (c *Cpu) maxCycles() int {
+	if c.nmi || c.irq && !c.I() {
+		return interruptCycles
+	}
+	o := c.op(c.M.Read(c.PC))
+	t := o.T
+	if o.cross {
+		t++
+	}
+	if o.Mode == MODE_BRA {
+		t += 2
+	}
+	return t
+}
+
+// SetTrapPC makes Run stop with ErrTrap before executing the instruction at
+// addr. Test ROMs often loop at a known address on failure.
+func (c *Cpu) SetTrapPC(addr uint16) {
+	c.trap = addr
+	c.hasTrap = true
+}
+
+// SetBreakpoint makes RunUntilBreak stop before executing the instruction at
+// addr.
+func (c *Cpu) SetBreakpoint(addr uint16) {
+	if c.breakpoints == nil {
+		c.breakpoints = make(map[uint16]bool)
+	}
+	c.breakpoints[addr] = true
+}
+
+// ClearBreakpoint removes a breakpoint set by SetBreakpoint.
+func (c *Cpu) ClearBreakpoint(addr uint16) {
+	delete(c.breakpoints, addr)
 }
 
+// RunUntilBreak steps until PC is at a breakpoint, returning it, or, as with
+// Run, PC is 0, returning halted. The instruction at PC is always run first,
+// so that calling RunUntilBreak again resumes from a breakpoint. If Step
+// returns an error, RunUntilBreak stops after that instruction and returns it.
+func (c *Cpu) RunUntilBreak() (hit uint16, halted bool, err error) {
+	for first := true; ; first = false {
+		if c.PC == 0 {
+			return 0, true, nil
+		}
+		if !first && c.breakpoints[c.PC] {
+			return c.PC, false, nil
+		}
+		if err := c.Step(); err != nil {
+			return c.PC, false, err
+		}
+	}
+}
+
+// WatchWrite calls f after each write to addr by an instruction or Poke, with
+// the value there before and after. Memory is read to get the old value only
+// for watched addresses. Writes straight to M are not seen.
+func (c *Cpu) WatchWrite(addr uint16, f func(addr uint16, old, new byte)) {
+	if c.watches == nil {
+		c.watches = make(map[uint16][]func(uint16, byte, byte))
+	}
+	c.watches[addr] = append(c.watches[addr], f)
+}
+
+// Override replaces the instruction at opcode for this Cpu only, keeping its
+// cycle count. Other Cpus continue to use Optable. Override is not safe to
+// call concurrently with Step. Logs name an instruction after its function,
+// so f should be a named function: a closure prints as "func1".
+func (c *Cpu) Override(opcode byte, f Func, m Mode) {
+	if c.ops == nil {
+		ops := Optable
+		c.ops = &ops
+	}
+	c.ops[opcode] = &Op{
+		F:    f,
+		Mode: m,
+		T:    c.ops[opcode].T,
+		name: funcName(f),
+	}
+}
+
+// op returns the instruction at opcode, honoring any Override.
+func (c *Cpu) op(opcode byte) *Op {
+	if c.ops != nil {
+		return c.ops[opcode]
+	}
+	return Optable[opcode]
+}
+
+// Reset performs the power-on sequence: S is set to 0xFD, interrupts are
+// disabled, decimal mode is cleared, and PC is loaded from the reset vector.
+// New does not reset the Cpu.
 func (c *Cpu) Reset() {
+	c.S = 0xfd
+	c.SEI()
+	c.CLD()
 	c.PC = uint16(c.M.Read(RESET+1))<<8 | uint16(c.M.Read(RESET))
 }
 
@@ -188,17 +550,61 @@ func (c *Cpu) Tick(i int) {
 			c.T.Tick()
 		}
 		c.stepCycles++
+		c.Cycles++
 	}
 }
 
-func (c *Cpu) Step() {
+// StepWith runs Step and then calls hook with c, whatever Step returned.
+func (c *Cpu) StepWith(hook func(*Cpu)) error {
+	err := c.Step()
+	hook(c)
+	return err
+}
+
+// UnknownOpcodeError is returned by Step after running an unimplemented
+// opcode, which it does as a NOP.
+type UnknownOpcodeError struct {
+	Opcode byte
+	PC     uint16
+}
+
+func (e *UnknownOpcodeError) Error() string {
+	return fmt.Sprintf("cpu6502: unknown opcode %02X at %04X", e.Opcode, e.PC)
+}
+
+// Step services a pending interrupt or executes one instruction. An interrupt
+// raised during an instruction is serviced by the next Step. Unimplemented
+// opcodes run as NOPs and return an *UnknownOpcodeError.
+func (c *Cpu) Step() error {
+	c.replayEvents()
 	pc := c.PC
 	c.stepCycles = 0
+	if c.nmi {
+		c.nmi = false
+		c.interrupt(NMI)
+		return nil
+	} else if c.irq && !c.I() {
+		c.interrupt(IRQ)
+		return nil
+	}
+	if c.Coverage || c.SMC != nil {
+		if c.executed == nil {
+			c.executed = make([]bool, 0xffff+1)
+		}
+		c.executed[pc] = true
+		if c.SMC != nil && c.written != nil && c.written[pc] {
+			c.SMC(pc)
+		}
+	}
 	inst := c.M.Read(c.PC)
 	c.PC++
-	o := Optable[inst]
+	o := c.op(inst)
+	if o.illegal && !c.AllowIllegal {
+		o = gatedOps[inst]
+	}
 	var b byte
 	var v, t uint16
+	var crossed bool
 	switch o.Mode {
 	case MODE_IMM, MODE_BRA:
 		b = c.M.Read(c.PC)
@@ -232,6 +638,7 @@ func (c *Cpu) Step() {
 		c.PC++
 		v = t + uint16(c.X)
 		b = c.M.Read(v)
+		crossed = t&0xff00 != v&0xff00
 	case MODE_ABSY:
 		t = uint16(c.M.Read(c.PC))
 		c.PC++
@@ -239,15 +646,12 @@ func (c *Cpu) Step() {
 		c.PC++
 		v = t + uint16(c.Y)
 		b = c.M.Read(v)
+		crossed = t&0xff00 != v&0xff00
 	case MODE_IND:
 		t = uint16(c.M.Read(c.PC))
 		c.PC++
 		t |= uint16(c.M.Read(c.PC)) << 8
-		t1 := t + 1
-		if t&0xff == 0xff {
-			t1 = t & 0xff00
-		}
-		v = uint16(c.M.Read(t)) + uint16(c.M.Read(t1))<<8
+		v = c.indirect(t)
 		c.PC++
 	case MODE_INDX:
 		t = uint16(c.M.Read(c.PC))
@@ -263,16 +667,24 @@ func (c *Cpu) Step() {
 		c.PC++
 		t1 := t + 1
 		t1 &= 0xff
-		v = uint16(c.M.Read(t)) + uint16(c.M.Read(t1))<<8 + uint16(c.Y)
+		base := uint16(c.M.Read(t)) + uint16(c.M.Read(t1))<<8
+		v = base + uint16(c.Y)
 		b = c.M.Read(v)
-	case MODE_SNGL:
+		crossed = base&0xff00 != v&0xff00
+	case MODE_IMP, MODE_ACC:
 		// nothing
 	default:
 		panic("6502: bad address mode")
 	}
 	o.F(c, b, v, o.Mode)
 	c.Tick(o.T)
-	if c.L != nil || c.Debug {
+	if crossed && o.cross {
+		c.Tick(1)
+	}
+	if c.Dev != nil {
+		c.Dev.Tick(uint64(c.stepCycles))
+	}
+	if len(c.L) != 0 || c.Debug || c.Trace != nil {
 		r := c.Register
 		r.PC = pc
 		l := Log{
@@ -284,7 +696,7 @@ func (c *Cpu) Step() {
 			T: t,
 			B: b,
 		}
-		if c.L != nil {
+		if len(c.L) != 0 {
 			c.L[c.LI] = l
 			c.LI++
 			c.LI %= len(c.L)
@@ -292,7 +704,53 @@ func (c *Cpu) Step() {
 		if c.Debug {
 			fmt.Println(l)
 		}
+		if c.Trace != nil {
+			c.Trace(l)
+		}
+	}
+	if o.filler {
+		return &UnknownOpcodeError{Opcode: inst, PC: pc}
+	}
+	return nil
+}
+
+// write writes b to v, reporting self-modifying code to SMC.
+func (c *Cpu) write(v uint16, b byte) {
+	c.store(v, b)
+	if c.SMC == nil {
+		return
+	}
+	if c.written == nil {
+		c.written = make([]bool, 0xffff+1)
+	}
+	c.written[v] = true
+	if c.executed != nil && c.executed[v] {
+		c.SMC(v)
+	}
+}
+
+// store writes b to v and calls any watches of v.
+func (c *Cpu) store(v uint16, b byte) {
+	w := c.watches[v]
+	if w == nil {
+		c.M.Write(v, b)
+		return
+	}
+	old := c.M.Read(v)
+	c.M.Write(v, b)
+	for _, f := range w {
+		f(v, old, b)
+	}
+}
+
+// indirect returns the address stored at t. Unless DisableJMPBug is set, a
+// pointer at the end of a page wraps to its start for the high byte.
+func (c *Cpu) indirect(t uint16) uint16 {
+	t1 := t + 1
+	if t&0xff == 0xff && !c.DisableJMPBug {
+		t1 = t & 0xff00
 	}
+	return uint16(c.M.Read(t)) | uint16(c.M.Read(t1))<<8
 }
 
 func (c *Cpu) setNZ(v byte) {
@@ -326,6 +784,39 @@ func (c *Cpu) V() bool       { return c.p(P_V) }
 func (c *Cpu) N() bool       { return c.p(P_N) }
 func (c *Cpu) p(v byte) bool { return c.P&v != 0 }
 
+// Flags is the status register unpacked.
+type Flags struct {
+	N, V, B, D, I, Z, C bool
+}
+
+// Flags returns the status register.
+func (c *Cpu) Flags() Flags {
+	return Flags{
+		N: c.N(),
+		V: c.V(),
+		B: c.B(),
+		D: c.D(),
+		I: c.I(),
+		Z: c.Z(),
+		C: c.C(),
+	}
+}
+
+// SetFlags sets the status register to f. The unused bit 5 is always set.
+func (c *Cpu) SetFlags(f Flags) {
+	c.P = P_X
+	for _, b := range []struct {
+		set bool
+		p   byte
+	}{
+		{f.N, P_N}, {f.V, P_V}, {f.B, P_B}, {f.D, P_D}, {f.I, P_I}, {f.Z, P_Z}, {f.C, P_C},
+	} {
+		if b.set {
+			c.P |= b.p
+		}
+	}
+}
+
 const (
 	P_C byte = 1 << iota
 	P_Z
@@ -349,85 +840,143 @@ func (c *Cpu) String() string {
 	return s
 }
 
+// RegisterState is a snapshot of the registers and cycle count.
+type RegisterState struct {
+	A, X, Y, S, P byte
+	PC            uint16
+	Cycles        uint64
+}
+
+// Registers returns a snapshot of c's registers.
+func (c *Cpu) Registers() RegisterState {
+	return RegisterState{
+		A:      c.A,
+		X:      c.X,
+		Y:      c.Y,
+		S:      c.S,
+		P:      c.P,
+		PC:     c.PC,
+		Cycles: c.Cycles,
+	}
+}
+
+// checkStores returns an error if a store instruction in ops has a mode with
+// no address to store to.
+func checkStores(ops []Instruction) error {
+	for _, i := range ops {
+		switch i.Name {
+		case "STA", "STX", "STY", "SAX":
+		default:
+			continue
+		}
+		var err error
+		i.each(func(m Mode, v byte) {
+			switch m {
+			case MODE_IMM, MODE_IMP, MODE_ACC, MODE_BRA:
+				if v != null && err == nil {
+					err = fmt.Errorf("cpu6502: %s %02x: store in mode %d", i.Name, v, m)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func init() {
-	populate := func(i Instruction, m Mode, v byte) {
+	for _, ops := range [][]Instruction{Opcodes, Unofficial} {
+		if err := checkStores(ops); err != nil {
+			panic(err)
+		}
+	}
+	populate := func(i Instruction, m Mode, v byte, illegal bool) {
 		if v != null {
 			if Optable[v] != nil {
 				panic(fmt.Sprintf("duplicate instruction %02x", v))
-			} else if i.TIM[m] == 0 {
+			} else if i.TIM.cycles[m] == 0 {
 				panic("no timing information")
 			}
 			Optable[v] = &Op{
-				F:    i.F,
-				Mode: m,
-				T:    i.TIM[m],
+				F:       i.F,
+				Mode:    m,
+				T:       i.TIM.cycles[m],
+				cross:   i.TIM.cross,
+				illegal: illegal,
+				name:    i.Name,
 			}
 		}
 	}
 	for _, i := range Opcodes {
-		populate(i, MODE_IMM, i.Imm)
-		populate(i, MODE_ZP, i.ZP)
-		populate(i, MODE_ZPX, i.ZPX)
-		populate(i, MODE_ZPY, i.ZPY)
-		populate(i, MODE_ABS, i.ABS)
-		populate(i, MODE_ABSX, i.ABSX)
-		populate(i, MODE_ABSY, i.ABSY)
-		populate(i, MODE_IND, i.IND)
-		populate(i, MODE_INDX, i.INDX)
-		populate(i, MODE_INDY, i.INDY)
-		populate(i, MODE_SNGL, i.SNGL)
-		populate(i, MODE_BRA, i.BRA)
+		i.each(func(m Mode, v byte) {
+			populate(i, m, v, false)
+		})
+	}
+	for _, i := range Unofficial {
+		i.each(func(m Mode, v byte) {
+			populate(i, m, v, i.Name != "NOP")
+		})
 	}
 	Optable[0] = &Op{
 		F:    BRK,
 		Mode: MODE_BRA,
-		T:    _K[MODE_BRA],
+		T:    _K.cycles[MODE_BRA],
 	}
 	// populate empty slots with NOPs
 	oIM := &Op{
-		F:    NOP,
-		Mode: MODE_IMM,
-		T:    2,
+		F:      NOP,
+		Mode:   MODE_IMM,
+		T:      2,
+		filler: true,
 	}
 	oZP := &Op{
-		F:    NOP,
-		Mode: MODE_ZP,
-		T:    2,
+		F:      NOP,
+		Mode:   MODE_ZP,
+		T:      2,
+		filler: true,
 	}
 	oAB := &Op{
-		F:    NOP,
-		Mode: MODE_ABS,
-		T:    3,
+		F:      NOP,
+		Mode:   MODE_ABS,
+		T:      3,
+		filler: true,
 	}
 	oSN := &Op{
-		F:    NOP,
-		Mode: MODE_SNGL,
-		T:    1,
+		F:      NOP,
+		Mode:   MODE_IMP,
+		T:      1,
+		filler: true,
 	}
 	oIX := &Op{
-		F:    NOP,
-		Mode: MODE_INDX,
-		T:    3,
+		F:      NOP,
+		Mode:   MODE_INDX,
+		T:      3,
+		filler: true,
 	}
 	oIY := &Op{
-		F:    NOP,
-		Mode: MODE_INDY,
-		T:    3,
+		F:      NOP,
+		Mode:   MODE_INDY,
+		T:      3,
+		filler: true,
 	}
 	oZX := &Op{
-		F:    NOP,
-		Mode: MODE_ZPX,
-		T:    3,
+		F:      NOP,
+		Mode:   MODE_ZPX,
+		T:      3,
+		filler: true,
 	}
 	oAX := &Op{
-		F:    NOP,
-		Mode: MODE_ABSX,
-		T:    3,
+		F:      NOP,
+		Mode:   MODE_ABSX,
+		T:      3,
+		filler: true,
 	}
 	oAY := &Op{
-		F:    NOP,
-		Mode: MODE_ABSY,
-		T:    3,
+		F:      NOP,
+		Mode:   MODE_ABSY,
+		T:      3,
+		filler: true,
 	}
 	for i, o := range Optable {
 		if o != nil {
@@ -456,24 +1005,90 @@ func init() {
 			panic("6502: missing NOP")
 		}
 	}
+	Optable[0].name = "BRK"
+	for i, o := range Optable {
+		if o.filler {
+			o.name = "NOP"
+		}
+		if o.illegal {
+			gatedOps[i] = &Op{F: NOP, Mode: o.Mode, T: o.T, filler: true, cross: o.cross, name: "NOP"}
+		}
+	}
+}
+
+// SetIRQLine sets the level of the IRQ line. While it is asserted and the I
+// flag is clear, each Step services an IRQ instead of an instruction.
+func (c *Cpu) SetIRQLine(asserted bool) {
+	if c.irq != asserted {
+		var v byte
+		if asserted {
+			v = 1
+		}
+		c.record(Event{Kind: EventIRQ, Value: v})
+	}
+	c.irq = asserted
+}
+
+// TriggerNMI signals an NMI, which the next Step services once.
+func (c *Cpu) TriggerNMI() {
+	c.record(Event{Kind: EventNMI})
+	c.nmi = true
+}
+
+// interruptCycles is the latency of an interrupt: pushing PC and P and loading
+// the vector.
+const interruptCycles = 7
+
+// interrupt pushes PC and P, with B clear, and jumps through vector.
+func (c *Cpu) interrupt(vector uint16) {
+	c.push16(c.PC)
+	c.pushStatus(false)
+	c.P |= P_I
+	c.PC = uint16(c.M.Read(vector)) + uint16(c.M.Read(vector+1))<<8
+	c.Tick(interruptCycles)
+	if c.Dev != nil {
+		c.Dev.Tick(uint64(c.stepCycles))
+	}
 }
 
+// Interrupt services an IRQ now, regardless of the I flag.
 func (c *Cpu) Interrupt() {
-	BRK(c, 0, 0, 0)
-	c.Tick(Optable[0].T)
+	c.stepCycles = 0
+	c.interrupt(IRQ)
+}
+
+// IRQ services an IRQ now unless the I flag is set.
+func (c *Cpu) IRQ() {
+	if !c.I() {
+		c.Interrupt()
+	}
+}
+
+// NMI services an NMI now, regardless of the I flag.
+func (c *Cpu) NMI() {
+	c.stepCycles = 0
+	c.interrupt(NMI)
 }
 
 func BRK(c *Cpu, b byte, v uint16, m Mode) {
+	if c.HaltOnBRK {
+		c.PC = 0
+		return
+	}
 	a := uint16(c.M.Read(IRQ)) + uint16(c.M.Read(IRQ+1))<<8
-	c.stackPush(byte(c.PC >> 8))
-	c.stackPush(byte(c.PC & 0xff))
-	c.stackPush(c.P | P_B)
+	c.push16(c.PC)
+	c.pushStatus(true)
 	c.PC = a
 	c.P |= P_I
 }
 
 func NOP(c *Cpu, b byte, v uint16, m Mode) {}
 
+// decimal reports whether ADC and SBC do BCD arithmetic.
+func (c *Cpu) decimal() bool {
+	return c.D() && c.DecimalEnabled && !c.DisableDecimal
+}
+
 func ADC(c *Cpu, b byte, v uint16, m Mode) {
 	if (c.A^b)&0x80 != 0 {
 		c.CLV()
@@ -481,7 +1096,7 @@ func ADC(c *Cpu, b byte, v uint16, m Mode) {
 		c.SEV()
 	}
 	var a uint16
-	if c.D() && !c.DisableDecimal {
+	if c.decimal() {
 		a = uint16(c.A&0xf) + uint16(b&0xf)
 		if c.C() {
 			a++
@@ -530,7 +1145,7 @@ func SBC(c *Cpu, b byte, v uint16, m Mode) {
 		c.CLV()
 	}
 	var a uint16
-	if c.D() && !c.DisableDecimal {
+	if c.decimal() {
 		var w uint16
 		a = 0xf + uint16(c.A&0xf) - uint16(b&0xf)
 		if c.C() {
@@ -593,15 +1208,15 @@ func LDY(c *Cpu, b byte, v uint16, m Mode) {
 }
 
 func STA(c *Cpu, b byte, v uint16, m Mode) {
-	c.M.Write(v, c.A)
+	c.write(v, c.A)
 }
 
 func STX(c *Cpu, b byte, v uint16, m Mode) {
-	c.M.Write(v, c.X)
+	c.write(v, c.X)
 }
 
 func STY(c *Cpu, b byte, v uint16, m Mode) {
-	c.M.Write(v, c.Y)
+	c.write(v, c.Y)
 }
 
 func TAX(c *Cpu, b byte, v uint16, m Mode) {
@@ -644,7 +1259,7 @@ func INY(c *Cpu, b byte, v uint16, m Mode) {
 }
 
 func INC(c *Cpu, b byte, v uint16, m Mode) {
-	c.M.Write(v, b+1)
+	c.write(v, b+1)
 	c.setNZ(c.M.Read(v))
 }
 
@@ -659,7 +1274,7 @@ func DEY(c *Cpu, b byte, v uint16, m Mode) {
 }
 
 func DEC(c *Cpu, b byte, v uint16, m Mode) {
-	c.M.Write(v, b-1)
+	c.write(v, b-1)
 	c.setNZ(c.M.Read(v))
 }
 
@@ -724,13 +1339,19 @@ func BVS(c *Cpu, b byte, v uint16, m Mode) {
 	}
 }
 
+// jump takes a branch with offset v, adding a cycle for the branch and
+// another if it crosses a page.
 func (c *Cpu) jump(v uint16) {
 	c.Tick(1)
+	pc := c.PC
 	if v > 0x7f {
 		c.PC -= 0x100 - v
 	} else {
 		c.PC += v
 	}
+	if pc&0xff00 != c.PC&0xff00 {
+		c.Tick(1)
+	}
 }
 
 func JMP(c *Cpu, b byte, v uint16, m Mode) {
@@ -738,33 +1359,71 @@ func JMP(c *Cpu, b byte, v uint16, m Mode) {
 }
 
 func PHA(c *Cpu, b byte, v uint16, m Mode) {
-	c.stackPush(c.A)
+	c.push8(c.A)
 }
 
 func PLA(c *Cpu, b byte, v uint16, m Mode) {
-	c.A = c.stackPop()
+	c.A = c.pull8()
 	c.setNZ(c.A)
 }
 
-func (c *Cpu) stackPush(b byte) {
-	c.M.Write(uint16(c.S)+0x100, b)
+// push8 pushes b onto the stack at 0x0100+S. S wraps within page 1.
+func (c *Cpu) push8(b byte) {
+	c.write(uint16(c.S)+0x100, b)
 	c.S = (c.S - 1) & 0xff
 }
 
-func (c *Cpu) stackPop() byte {
+// pull8 pulls a byte from the stack at 0x0100+S. S wraps within page 1.
+func (c *Cpu) pull8() byte {
 	c.S = (c.S + 1) & 0xff
 	return c.M.Read(uint16(c.S) + 0x100)
 }
 
+// push16 pushes v, high byte first.
+func (c *Cpu) push16(v uint16) {
+	c.push8(byte(v >> 8))
+	c.push8(byte(v))
+}
+
+// pull16 pulls a value pushed by push16.
+func (c *Cpu) pull16() uint16 {
+	lo := c.pull8()
+	return uint16(lo) | uint16(c.pull8())<<8
+}
+
+// pushStatus pushes P with bit 5 set. B is set if brk, as for BRK and PHP,
+// and clear otherwise, as for IRQ and NMI. The live P has no B flag.
+func (c *Cpu) pushStatus(brk bool) {
+	p := c.P&^P_B | P_X
+	if brk {
+		p |= P_B
+	}
+	c.push8(p)
+}
+
+// pullStatus pulls P, ignoring the pushed B and bit 5.
+func (c *Cpu) pullStatus() {
+	c.P = c.pull8()&^P_B | P_X
+}
+
+// StackDump returns the live portion of the stack, from S+1 to the top of
+// page 1. The most recently pushed byte is first.
+func (c *Cpu) StackDump() []byte {
+	b := make([]byte, 0, 0xff-int(c.S))
+	for i := int(c.S) + 1; i <= 0xff; i++ {
+		b = append(b, c.M.Read(uint16(i)+0x100))
+	}
+	return b
+}
+
 func JSR(c *Cpu, b byte, v uint16, m Mode) {
 	a := c.PC - 1
-	c.stackPush(byte(a >> 8))
-	c.stackPush(byte(a & 0xff))
+	c.push16(a)
 	c.PC = v
 }
 
 func RTS(c *Cpu, b byte, v uint16, m Mode) {
-	c.PC = (uint16(c.stackPop()) | uint16(c.stackPop())<<8)
+	c.PC = c.pull16()
 	c.PC++
 }
 
@@ -779,13 +1438,13 @@ func ORA(c *Cpu, b byte, v uint16, m Mode) {
 }
 
 func ASL(c *Cpu, b byte, v uint16, m Mode) {
-	if m == MODE_SNGL {
+	if m == MODE_ACC {
 		c.setCarryBit(c.A, 7)
 		c.A <<= 1
 		c.setNZ(c.A)
 	} else {
 		c.setCarryBit(c.M.Read(v), 7)
-		c.M.Write(v, c.M.Read(v)<<1)
+		c.write(v, c.M.Read(v)<<1)
 		c.setNZ(c.M.Read(v))
 	}
 }
@@ -795,27 +1454,27 @@ func ROL(c *Cpu, b byte, v uint16, m Mode) {
 	if c.C() {
 		s = 0x01
 	}
-	if m == MODE_SNGL {
+	if m == MODE_ACC {
 		c.setCarryBit(c.A, 7)
 		c.A <<= 1
 		c.A |= s
 		c.setNZ(c.A)
 	} else {
 		c.setCarryBit(c.M.Read(v), 7)
-		c.M.Write(v, c.M.Read(v)<<1)
-		c.M.Write(v, c.M.Read(v)|s)
+		c.write(v, c.M.Read(v)<<1)
+		c.write(v, c.M.Read(v)|s)
 		c.setNZ(c.M.Read(v))
 	}
 }
 
 func LSR(c *Cpu, b byte, v uint16, m Mode) {
-	if m == MODE_SNGL {
+	if m == MODE_ACC {
 		c.setCarryBit(c.A, 0)
 		c.A >>= 1
 		c.setNZ(c.A)
 	} else {
 		c.setCarryBit(c.M.Read(v), 0)
-		c.M.Write(v, c.M.Read(v)>>1)
+		c.write(v, c.M.Read(v)>>1)
 		c.setNZ(c.M.Read(v))
 	}
 }
@@ -825,15 +1484,15 @@ func ROR(c *Cpu, b byte, v uint16, m Mode) {
 	if c.C() {
 		s = 0x80
 	}
-	if m == MODE_SNGL {
+	if m == MODE_ACC {
 		c.setCarryBit(c.A, 0)
 		c.A >>= 1
 		c.A |= s
 		c.setNZ(c.A)
 	} else {
 		c.setCarryBit(c.M.Read(v), 0)
-		c.M.Write(v, c.M.Read(v)>>1)
-		c.M.Write(v, c.M.Read(v)|s)
+		c.write(v, c.M.Read(v)>>1)
+		c.write(v, c.M.Read(v)|s)
 		c.setNZ(c.M.Read(v))
 	}
 }
@@ -898,17 +1557,16 @@ func EOR(c *Cpu, b byte, v uint16, m Mode) {
 }
 
 func PHP(c *Cpu, b byte, v uint16, m Mode) {
-	c.stackPush(c.P | P_X | P_B)
+	c.pushStatus(true)
 }
 
 func PLP(c *Cpu, b byte, v uint16, m Mode) {
-	c.P = c.stackPop() | P_X
-	c.P &= ^P_B
+	c.pullStatus()
 }
 
 func RTI(c *Cpu, b byte, v uint16, m Mode) {
-	c.P = c.stackPop() | P_X
-	c.PC = uint16(c.stackPop()) + uint16(c.stackPop())<<8
+	c.pullStatus()
+	c.PC = c.pull16()
 }
 
 func TRB(c *Cpu, b byte, v uint16, m Mode) {
@@ -917,7 +1575,7 @@ func TRB(c *Cpu, b byte, v uint16, m Mode) {
 	} else {
 		c.P |= P_Z
 	}
-	c.M.Write(v, c.M.Read(v) & ^c.A)
+	c.write(v, c.M.Read(v) & ^c.A)
 }
 
 func TSB(c *Cpu, b byte, v uint16, m Mode) {
@@ -926,134 +1584,170 @@ func TSB(c *Cpu, b byte, v uint16, m Mode) {
 	} else {
 		c.P |= P_Z
 	}
-	c.M.Write(v, c.M.Read(v)|c.A)
+	c.write(v, c.M.Read(v)|c.A)
 }
 
 const null = 0
 
 var (
 	_1 = timing{
-		MODE_IMM:  2,
-		MODE_ZP:   3,
-		MODE_ZPX:  4,
-		MODE_ZPY:  4,
-		MODE_ABS:  4,
-		MODE_ABSX: 4,
-		MODE_ABSY: 4,
-		MODE_INDX: 6,
-		MODE_INDY: 5,
+		cross: true,
+		cycles: map[Mode]int{
+			MODE_IMM:  2,
+			MODE_ZP:   3,
+			MODE_ZPX:  4,
+			MODE_ZPY:  4,
+			MODE_ABS:  4,
+			MODE_ABSX: 4,
+			MODE_ABSY: 4,
+			MODE_INDX: 6,
+			MODE_INDY: 5,
+		},
 	}
 	_2 = timing{
-		MODE_BRA:  2,
-		MODE_SNGL: 2,
-		MODE_IMM:  2,
-		MODE_ZP:   5,
-		MODE_ZPX:  6,
-		MODE_ABS:  6,
-		MODE_ABSX: 7,
-		MODE_ABSY: 7,
-		MODE_INDX: 8,
-		MODE_INDY: 8,
+		cycles: map[Mode]int{
+			MODE_BRA:  2,
+			MODE_IMP:  2,
+			MODE_ACC:  2,
+			MODE_IMM:  2,
+			MODE_ZP:   5,
+			MODE_ZPX:  6,
+			MODE_ABS:  6,
+			MODE_ABSX: 7,
+			MODE_ABSY: 7,
+			MODE_INDX: 8,
+			MODE_INDY: 8,
+		},
 	}
 	_3 = timing{
-		MODE_SNGL: 3,
-		MODE_IMM:  2,
-		MODE_ZP:   3,
-		MODE_ZPX:  4,
-		MODE_ZPY:  4,
-		MODE_ABS:  4,
-		MODE_ABSX: 5,
-		MODE_ABSY: 5,
-		MODE_INDX: 6,
-		MODE_INDY: 6,
+		cycles: map[Mode]int{
+			MODE_IMP:  3,
+			MODE_IMM:  2,
+			MODE_ZP:   3,
+			MODE_ZPX:  4,
+			MODE_ZPY:  4,
+			MODE_ABS:  4,
+			MODE_ABSX: 5,
+			MODE_ABSY: 5,
+			MODE_INDX: 6,
+			MODE_INDY: 6,
+		},
 	}
 	_S4 = timing{
-		MODE_SNGL: 4,
+		cycles: map[Mode]int{
+			MODE_IMP: 4,
+		},
 	}
 	_S6 = timing{
-		MODE_SNGL: 6,
+		cycles: map[Mode]int{
+			MODE_IMP: 6,
+		},
 	}
 	_K = timing{
-		MODE_BRA: 7,
+		cycles: map[Mode]int{
+			MODE_BRA: 7,
+		},
 	}
 	_J = timing{
-		MODE_ABS: 3,
-		MODE_IND: 5,
+		cycles: map[Mode]int{
+			MODE_ABS: 3,
+			MODE_IND: 5,
+		},
+	}
+	_N = timing{
+		cross: true,
+		cycles: map[Mode]int{
+			MODE_IMM:  2,
+			MODE_ZP:   3,
+			MODE_ZPX:  4,
+			MODE_ABS:  4,
+			MODE_ABSX: 4,
+			MODE_IMP:  2,
+		},
 	}
 )
 
 var Opcodes = []Instruction{
-	/* F,  Imm,   ZP,  ZPX,  ZPY,  ABS, ABSX, ABSY,  IND, INDX, INDY, SNGL,  BRA, TIM */
-	{ADC, 0x69, 0x65, 0x75, null, 0x6d, 0x7d, 0x79, null, 0x61, 0x71, null, null, _1},
-	{AND, 0x29, 0x25, 0x35, null, 0x2d, 0x3d, 0x39, null, 0x21, 0x31, null, null, _1},
-	{ASL, null, 0x06, 0x16, null, 0x0e, 0x1e, null, null, null, null, 0x0a, null, _2},
-	{BCC, null, null, null, null, null, null, null, null, null, null, null, 0x90, _2},
-	{BCS, null, null, null, null, null, null, null, null, null, null, null, 0xb0, _2},
-	{BEQ, null, null, null, null, null, null, null, null, null, null, null, 0xf0, _2},
-	{BIT, null, 0x24, null, null, 0x2c, null, null, null, null, null, null, null, _3},
-	{BMI, null, null, null, null, null, null, null, null, null, null, null, 0x30, _2},
-	{BNE, null, null, null, null, null, null, null, null, null, null, null, 0xd0, _2},
-	{BPL, null, null, null, null, null, null, null, null, null, null, null, 0x10, _2},
-	{BRK, null, null, null, null, null, null, null, null, null, null, null, 0x00, _K},
-	{BVC, null, null, null, null, null, null, null, null, null, null, null, 0x50, _2},
-	{BVS, null, null, null, null, null, null, null, null, null, null, null, 0x70, _2},
-	{CLC, null, null, null, null, null, null, null, null, null, null, 0x18, null, _2},
-	{CLD, null, null, null, null, null, null, null, null, null, null, 0xd8, null, _2},
-	{CLI, null, null, null, null, null, null, null, null, null, null, 0x58, null, _2},
-	{CLV, null, null, null, null, null, null, null, null, null, null, 0xb8, null, _2},
-	{CMP, 0xc9, 0xc5, 0xd5, null, 0xcd, 0xdd, 0xd9, null, 0xc1, 0xd1, null, null, _1},
-	{CPX, 0xe0, 0xe4, null, null, 0xec, null, null, null, null, null, null, null, _2},
-	{CPY, 0xc0, 0xc4, null, null, 0xcc, null, null, null, null, null, null, null, _2},
-	{DEC, null, 0xc6, 0xd6, null, 0xce, 0xde, null, null, null, null, null, null, _2},
-	{DEX, null, null, null, null, null, null, null, null, null, null, 0xca, null, _2},
-	{DEY, null, null, null, null, null, null, null, null, null, null, 0x88, null, _2},
-	{EOR, 0x49, 0x45, 0x55, null, 0x4d, 0x5d, 0x59, null, 0x41, 0x51, null, null, _1},
-	{INC, null, 0xe6, 0xf6, null, 0xee, 0xfe, null, null, null, null, null, null, _2},
-	{INX, null, null, null, null, null, null, null, null, null, null, 0xe8, null, _2},
-	{INY, null, null, null, null, null, null, null, null, null, null, 0xc8, null, _2},
-	{JMP, null, null, null, null, 0x4c, null, null, 0x6c, null, null, null, null, _J},
-	{JSR, null, null, null, null, 0x20, null, null, null, null, null, null, null, _2},
-	{LDA, 0xa9, 0xa5, 0xb5, null, 0xad, 0xbd, 0xb9, null, 0xa1, 0xb1, null, null, _1},
-	{LDX, 0xa2, 0xa6, null, 0xb6, 0xae, null, 0xbe, null, null, null, null, null, _1},
-	{LDY, 0xa0, 0xa4, 0xb4, null, 0xac, 0xbc, null, null, null, null, null, null, _1},
-	{LSR, null, 0x46, 0x56, null, 0x4e, 0x5e, null, null, null, null, 0x4a, null, _2},
-	{NOP, null, null, null, null, null, null, null, null, null, null, 0xea, null, _2},
-	{ORA, 0x09, 0x05, 0x15, null, 0x0d, 0x1d, 0x19, null, 0x01, 0x11, null, null, _1},
-	{PHA, null, null, null, null, null, null, null, null, null, null, 0x48, null, _3},
-	{PHP, null, null, null, null, null, null, null, null, null, null, 0x08, null, _3},
-	{PLA, null, null, null, null, null, null, null, null, null, null, 0x68, null, _S4},
-	{PLP, null, null, null, null, null, null, null, null, null, null, 0x28, null, _S4},
-	{ROL, null, 0x26, 0x36, null, 0x2e, 0x3e, null, null, null, null, 0x2a, null, _2},
-	{ROR, null, 0x66, 0x76, null, 0x6e, 0x7e, null, null, null, null, 0x6a, null, _2},
-	{RTI, null, null, null, null, null, null, null, null, null, null, 0x40, null, _S6},
-	{RTS, null, null, null, null, null, null, null, null, null, null, 0x60, null, _S6},
-	{SBC, 0xe9, 0xe5, 0xf5, null, 0xed, 0xfd, 0xf9, null, 0xe1, 0xf1, null, null, _1},
-	{SEC, null, null, null, null, null, null, null, null, null, null, 0x38, null, _2},
-	{SED, null, null, null, null, null, null, null, null, null, null, 0xf8, null, _2},
-	{SEI, null, null, null, null, null, null, null, null, null, null, 0x78, null, _2},
-	{STA, null, 0x85, 0x95, null, 0x8d, 0x9d, 0x99, null, 0x81, 0x91, null, null, _3},
-	{STX, null, 0x86, null, 0x96, 0x8e, null, null, null, null, null, null, null, _3},
-	{STY, null, 0x84, 0x94, null, 0x8c, null, null, null, null, null, null, null, _3},
-	{TAX, null, null, null, null, null, null, null, null, null, null, 0xaa, null, _2},
-	{TAY, null, null, null, null, null, null, null, null, null, null, 0xa8, null, _2},
-	//{TRB, null, 0x14, null, null, 0x1c, null, null, null, null, null, null, null, _2},
-	//{TSB, null, 0x04, null, null, 0x0c, null, null, null, null, null, null, null, _2},
-	{TSX, null, null, null, null, null, null, null, null, null, null, 0xba, null, _2},
-	{TXA, null, null, null, null, null, null, null, null, null, null, 0x8a, null, _2},
-	{TXS, null, null, null, null, null, null, null, null, null, null, 0x9a, null, _2},
-	{TYA, null, null, null, null, null, null, null, null, null, null, 0x98, null, _2},
-
-	// Unofficial opcodes.
-	/* F,  Imm,   ZP,  ZPX,  ZPY,  ABS, ABSX, ABSY,  IND, INDX, INDY, SNGL,  BRA, TIM */
-	{LAX, 0xab, 0xa7, null, 0xb7, 0xaf, null, 0xbf, null, 0xa3, 0xb3, null, null, _1},
-	{SAX, null, 0x87, null, 0x97, 0x8f, null, null, null, 0x83, null, null, null, _3},
-	{SBC, 0xeb, null, null, null, null, null, null, null, null, null, null, null, _1},
-	{DCP, null, 0xc7, 0xd7, null, 0xcf, 0xdf, 0xdb, null, 0xc3, 0xd3, null, null, _2},
-	{ISC, null, 0xe7, 0xf7, null, 0xef, 0xff, 0xfb, null, 0xe3, 0xf3, null, null, _2},
-	{SLO, null, 0x07, 0x17, null, 0x0f, 0x1f, 0x1b, null, 0x03, 0x13, null, null, _2},
-	{RLA, null, 0x27, 0x37, null, 0x2f, 0x3f, 0x3b, null, 0x23, 0x33, null, null, _2},
-	{SRE, null, 0x47, 0x57, null, 0x4f, 0x5f, 0x5b, null, 0x43, 0x53, null, null, _2},
-	{RRA, null, 0x67, 0x77, null, 0x6f, 0x7f, 0x7b, null, 0x63, 0x73, null, null, _2},
+	/* Name, F,  Imm,   ZP,  ZPX,  ZPY,  ABS, ABSX, ABSY,  IND, INDX, INDY,  IMP,  ACC,  BRA, TIM */
+	{"ADC", ADC, 0x69, 0x65, 0x75, null, 0x6d, 0x7d, 0x79, null, 0x61, 0x71, null, null, null, _1},
+	{"AND", AND, 0x29, 0x25, 0x35, null, 0x2d, 0x3d, 0x39, null, 0x21, 0x31, null, null, null, _1},
+	{"ASL", ASL, null, 0x06, 0x16, null, 0x0e, 0x1e, null, null, null, null, null, 0x0a, null, _2},
+	{"BCC", BCC, null, null, null, null, null, null, null, null, null, null, null, null, 0x90, _2},
+	{"BCS", BCS, null, null, null, null, null, null, null, null, null, null, null, null, 0xb0, _2},
+	{"BEQ", BEQ, null, null, null, null, null, null, null, null, null, null, null, null, 0xf0, _2},
+	{"BIT", BIT, null, 0x24, null, null, 0x2c, null, null, null, null, null, null, null, null, _3},
+	{"BMI", BMI, null, null, null, null, null, null, null, null, null, null, null, null, 0x30, _2},
+	{"BNE", BNE, null, null, null, null, null, null, null, null, null, null, null, null, 0xd0, _2},
+	{"BPL", BPL, null, null, null, null, null, null, null, null, null, null, null, null, 0x10, _2},
+	{"BRK", BRK, null, null, null, null, null, null, null, null, null, null, null, null, 0x00, _K},
+	{"BVC", BVC, null, null, null, null, null, null, null, null, null, null, null, null, 0x50, _2},
+	{"BVS", BVS, null, null, null, null, null, null, null, null, null, null, null, null, 0x70, _2},
+	{"CLC", CLC, null, null, null, null, null, null, null, null, null, null, 0x18, null, null, _2},
+	{"CLD", CLD, null, null, null, null, null, null, null, null, null, null, 0xd8, null, null, _2},
+	{"CLI", CLI, null, null, null, null, null, null, null, null, null, null, 0x58, null, null, _2},
+	{"CLV", CLV, null, null, null, null, null, null, null, null, null, null, 0xb8, null, null, _2},
+	{"CMP", CMP, 0xc9, 0xc5, 0xd5, null, 0xcd, 0xdd, 0xd9, null, 0xc1, 0xd1, null, null, null, _1},
+	{"CPX", CPX, 0xe0, 0xe4, null, null, 0xec, null, null, null, null, null, null, null, null, _1},
+	{"CPY", CPY, 0xc0, 0xc4, null, null, 0xcc, null, null, null, null, null, null, null, null, _1},
+	{"DEC", DEC, null, 0xc6, 0xd6, null, 0xce, 0xde, null, null, null, null, null, null, null, _2},
+	{"DEX", DEX, null, null, null, null, null, null, null, null, null, null, 0xca, null, null, _2},
+	{"DEY", DEY, null, null, null, null, null, null, null, null, null, null, 0x88, null, null, _2},
+	{"EOR", EOR, 0x49, 0x45, 0x55, null, 0x4d, 0x5d, 0x59, null, 0x41, 0x51, null, null, null, _1},
+	{"INC", INC, null, 0xe6, 0xf6, null, 0xee, 0xfe, null, null, null, null, null, null, null, _2},
+	{"INX", INX, null, null, null, null, null, null, null, null, null, null, 0xe8, null, null, _2},
+	{"INY", INY, null, null, null, null, null, null, null, null, null, null, 0xc8, null, null, _2},
+	{"JMP", JMP, null, null, null, null, 0x4c, null, null, 0x6c, null, null, null, null, null, _J},
+	{"JSR", JSR, null, null, null, null, 0x20, null, null, null, null, null, null, null, null, _2},
+	{"LDA", LDA, 0xa9, 0xa5, 0xb5, null, 0xad, 0xbd, 0xb9, null, 0xa1, 0xb1, null, null, null, _1},
+	{"LDX", LDX, 0xa2, 0xa6, null, 0xb6, 0xae, null, 0xbe, null, null, null, null, null, null, _1},
+	{"LDY", LDY, 0xa0, 0xa4, 0xb4, null, 0xac, 0xbc, null, null, null, null, null, null, null, _1},
+	{"LSR", LSR, null, 0x46, 0x56, null, 0x4e, 0x5e, null, null, null, null, null, 0x4a, null, _2},
+	{"NOP", NOP, null, null, null, null, null, null, null, null, null, null, 0xea, null, null, _2},
+	{"ORA", ORA, 0x09, 0x05, 0x15, null, 0x0d, 0x1d, 0x19, null, 0x01, 0x11, null, null, null, _1},
+	{"PHA", PHA, null, null, null, null, null, null, null, null, null, null, 0x48, null, null, _3},
+	{"PHP", PHP, null, null, null, null, null, null, null, null, null, null, 0x08, null, null, _3},
+	{"PLA", PLA, null, null, null, null, null, null, null, null, null, null, 0x68, null, null, _S4},
+	{"PLP", PLP, null, null, null, null, null, null, null, null, null, null, 0x28, null, null, _S4},
+	{"ROL", ROL, null, 0x26, 0x36, null, 0x2e, 0x3e, null, null, null, null, null, 0x2a, null, _2},
+	{"ROR", ROR, null, 0x66, 0x76, null, 0x6e, 0x7e, null, null, null, null, null, 0x6a, null, _2},
+	{"RTI", RTI, null, null, null, null, null, null, null, null, null, null, 0x40, null, null, _S6},
+	{"RTS", RTS, null, null, null, null, null, null, null, null, null, null, 0x60, null, null, _S6},
+	{"SBC", SBC, 0xe9, 0xe5, 0xf5, null, 0xed, 0xfd, 0xf9, null, 0xe1, 0xf1, null, null, null, _1},
+	{"SEC", SEC, null, null, null, null, null, null, null, null, null, null, 0x38, null, null, _2},
+	{"SED", SED, null, null, null, null, null, null, null, null, null, null, 0xf8, null, null, _2},
+	{"SEI", SEI, null, null, null, null, null, null, null, null, null, null, 0x78, null, null, _2},
+	{"STA", STA, null, 0x85, 0x95, null, 0x8d, 0x9d, 0x99, null, 0x81, 0x91, null, null, null, _3},
+	{"STX", STX, null, 0x86, null, 0x96, 0x8e, null, null, null, null, null, null, null, null, _3},
+	{"STY", STY, null, 0x84, 0x94, null, 0x8c, null, null, null, null, null, null, null, null, _3},
+	{"TAX", TAX, null, null, null, null, null, null, null, null, null, null, 0xaa, null, null, _2},
+	{"TAY", TAY, null, null, null, null, null, null, null, null, null, null, 0xa8, null, null, _2},
+	//{"TRB", TRB, null, 0x14, null, null, 0x1c, null, null, null, null, null, null, null, null, _2},
+	//{"TSB", TSB, null, 0x04, null, null, 0x0c, null, null, null, null, null, null, null, null, _2},
+	{"TSX", TSX, null, null, null, null, null, null, null, null, null, null, 0xba, null, null, _2},
+	{"TXA", TXA, null, null, null, null, null, null, null, null, null, null, 0x8a, null, null, _2},
+	{"TXS", TXS, null, null, null, null, null, null, null, null, null, null, 0x9a, null, null, _2},
+	{"TYA", TYA, null, null, null, null, null, null, null, null, null, null, 0x98, null, null, _2},
+}
+
+// Unofficial are the stable unofficial opcodes, enabled by AllowIllegal. The
+// NOPs are always enabled.
+var Unofficial = []Instruction{
+	/* Name, F,  Imm,   ZP,  ZPX,  ZPY,  ABS, ABSX, ABSY,  IND, INDX, INDY,  IMP,  ACC,  BRA, TIM */
+	{"LAX", LAX, 0xab, 0xa7, null, 0xb7, 0xaf, null, 0xbf, null, 0xa3, 0xb3, null, null, null, _1},
+	{"SAX", SAX, null, 0x87, null, 0x97, 0x8f, null, null, null, 0x83, null, null, null, null, _3},
+	{"USBC", SBC, 0xeb, null, null, null, null, null, null, null, null, null, null, null, null, _1},
+	{"DCP", DCP, null, 0xc7, 0xd7, null, 0xcf, 0xdf, 0xdb, null, 0xc3, 0xd3, null, null, null, _2},
+	{"ISC", ISC, null, 0xe7, 0xf7, null, 0xef, 0xff, 0xfb, null, 0xe3, 0xf3, null, null, null, _2},
+	{"SLO", SLO, null, 0x07, 0x17, null, 0x0f, 0x1f, 0x1b, null, 0x03, 0x13, null, null, null, _2},
+	{"RLA", RLA, null, 0x27, 0x37, null, 0x2f, 0x3f, 0x3b, null, 0x23, 0x33, null, null, null, _2},
+	{"SRE", SRE, null, 0x47, 0x57, null, 0x4f, 0x5f, 0x5b, null, 0x43, 0x53, null, null, null, _2},
+	{"RRA", RRA, null, 0x67, 0x77, null, 0x6f, 0x7f, 0x7b, null, 0x63, 0x73, null, null, null, _2},
+	{"NOP", NOP, 0x80, 0x04, 0x14, null, 0x0c, 0x1c, null, null, null, null, 0x1a, null, null, _N},
+	{"NOP", NOP, 0x82, 0x44, 0x34, null, null, 0x3c, null, null, null, null, 0x3a, null, null, _N},
+	{"NOP", NOP, 0x89, 0x64, 0x54, null, null, 0x5c, null, null, null, null, 0x5a, null, null, _N},
+	{"NOP", NOP, 0xc2, null, 0x74, null, null, 0x7c, null, null, null, null, 0x7a, null, null, _N},
+	{"NOP", NOP, 0xe2, null, 0xd4, null, null, 0xdc, null, null, null, null, 0xda, null, null, _N},
+	{"NOP", NOP, null, null, 0xf4, null, null, 0xfc, null, null, null, null, 0xfa, null, null, _N},
 }
 
 // Unofficial instructions.
@@ -1064,7 +1758,7 @@ func LAX(c *Cpu, b byte, v uint16, m Mode) {
 }
 
 func SAX(c *Cpu, b byte, v uint16, m Mode) {
-	c.M.Write(v, c.X&c.A)
+	c.write(v, c.X&c.A)
 }
 
 func DCP(c *Cpu, b byte, v uint16, m Mode) {
@@ -17,7 +17,11 @@
 package cpu6502
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -36,6 +40,7 @@ func TestFunctional(t *testing.T) {
 	r := make(Ram, 0xffff+1)
 	copy(r[:], b)
 	c := New(r)
+	c.DecimalEnabled = true
 	c.L = make([]Log, 20)
 	c.PC = 0x0400
 	i := 0
@@ -58,3 +63,1575 @@ func TestFunctional(t *testing.T) {
 		i++
 	}
 }
+
+// newTest returns a Cpu with prog loaded at 0x0600 in a fresh 64KB Ram.
+func newTest(prog ...byte) (*Cpu, Ram) {
+	r := make(Ram, 0xffff+1)
+	copy(r[0x0600:], prog)
+	return NewAt(r, 0x0600), r
+}
+
+func TestNewAt(t *testing.T) {
+	c := NewAt(make(Ram, 0xffff+1), 0x1000)
+	if c.PC != 0x1000 || c.S != 0xff || !c.I() {
+		t.Fatalf("got PC=%04X S=%02X I=%v", c.PC, c.S, c.I())
+	}
+	if c := New(make(Ram, 0xffff+1)); c.PC != 0 {
+		t.Fatalf("New: got PC=%04X", c.PC)
+	}
+}
+
+func TestAbsoluteEndian(t *testing.T) {
+	tests := []struct {
+		prog []byte
+		x, y byte
+		addr uint16
+	}{
+		{[]byte{0xad, 0x34, 0x12}, 0, 0, 0x1234}, // LDA $1234
+		{[]byte{0xbd, 0x34, 0x12}, 1, 0, 0x1235}, // LDA $1234,X
+		{[]byte{0xb9, 0x34, 0x12}, 0, 2, 0x1236}, // LDA $1234,Y
+	}
+	for _, test := range tests {
+		c, r := newTest(test.prog...)
+		c.X, c.Y = test.x, test.y
+		r[test.addr] = 0x42
+		r[0x3412+uint16(test.x)+uint16(test.y)] = 0x99
+		c.Step()
+		if c.A != 0x42 {
+			t.Errorf("%02X: got A=%02X, expected %02X from $%04X", test.prog[0], c.A, 0x42, test.addr)
+		}
+	}
+}
+
+func TestStackDump(t *testing.T) {
+	c, _ := newTest(0xa9, 0x11, 0x48, 0xa9, 0x22, 0x48) // LDA #$11; PHA; LDA #$22; PHA
+	if s := c.StackDump(); len(s) != 0 {
+		t.Fatalf("expected empty stack, got % X", s)
+	}
+	for i := 0; i < 4; i++ {
+		c.Step()
+	}
+	s := c.StackDump()
+	if len(s) != 2 || s[0] != 0x22 || s[1] != 0x11 {
+		t.Fatalf("got % X, expected 22 11", s)
+	}
+}
+
+func TestADCCarryIn(t *testing.T) {
+	tests := []struct {
+		carry byte // CLC or SEC
+		a     byte
+		c     bool
+	}{
+		{0x18, 0xff, false},
+		{0x38, 0x00, true},
+	}
+	for _, test := range tests {
+		c, _ := newTest(test.carry, 0xa9, 0xff, 0x69, 0x00) // LDA #$FF; ADC #$00
+		for i := 0; i < 3; i++ {
+			c.Step()
+		}
+		if c.A != test.a || c.C() != test.c {
+			t.Errorf("%02X: got A=%02X C=%v, expected A=%02X C=%v", test.carry, c.A, c.C(), test.a, test.c)
+		}
+	}
+}
+
+func TestOverride(t *testing.T) {
+	c, _ := newTest(0xa9, 0x42) // LDA #$42
+	c.Override(0xa9, func(c *Cpu, b byte, v uint16, m Mode) {
+		c.X = b
+	}, MODE_IMM)
+	c.Step()
+	if c.A != 0 || c.X != 0x42 {
+		t.Fatalf("override not run: A=%02X X=%02X", c.A, c.X)
+	}
+	d, _ := newTest(0xa9, 0x42)
+	d.Step()
+	if d.A != 0x42 {
+		t.Fatalf("override leaked to another Cpu: A=%02X", d.A)
+	}
+}
+
+func TestLDAModes(t *testing.T) {
+	const sentinel = 0x80
+	tests := []struct {
+		name string
+		prog []byte
+		addr uint16
+	}{
+		{"imm", []byte{0xa9, sentinel}, 0},
+		{"zp", []byte{0xa5, 0x10}, 0x0010},
+		{"zpx", []byte{0xb5, 0x10}, 0x0014},
+		{"abs", []byte{0xad, 0x00, 0x20}, 0x2000},
+		{"absx", []byte{0xbd, 0x00, 0x20}, 0x2004},
+		{"absy", []byte{0xb9, 0x00, 0x20}, 0x2008},
+		{"indx", []byte{0xa1, 0x20}, 0x3000},
+		{"indy", []byte{0xb1, 0x30}, 0x3108},
+	}
+	var p byte
+	for i, test := range tests {
+		c, r := newTest(test.prog...)
+		c.X, c.Y = 4, 8
+		r[0x24], r[0x25] = 0x00, 0x30
+		r[0x30], r[0x31] = 0x00, 0x31
+		if test.addr != 0 {
+			r[test.addr] = sentinel
+		}
+		c.Step()
+		if c.A != sentinel {
+			t.Errorf("%s: got A=%02X, expected %02X", test.name, c.A, sentinel)
+		}
+		if i == 0 {
+			p = c.P
+		} else if c.P != p {
+			t.Errorf("%s: got P=%08b, expected %08b", test.name, c.P, p)
+		}
+	}
+}
+
+func TestBranchBackward(t *testing.T) {
+	tests := []struct {
+		pc, target uint16
+		cycles     int
+	}{
+		{0x0615, 0x0607, 3},
+		{0x0605, 0x05f7, 4},
+	}
+	for _, test := range tests {
+		r := make(Ram, 0xffff+1)
+		r[test.pc], r[test.pc+1] = 0xd0, 0xf0 // BNE -16
+		c := New(r)
+		c.PC = test.pc
+		c.Step()
+		if c.PC != test.target || c.stepCycles != test.cycles {
+			t.Errorf("%04X: got PC=%04X in %d cycles, expected %04X in %d", test.pc, c.PC, c.stepCycles, test.target, test.cycles)
+		}
+	}
+}
+
+// ticks counts calls to Tick.
+type ticks uint64
+
+func (t *ticks) Tick() { *t++ }
+
+type device struct {
+	cycles uint64
+	calls  int
+}
+
+func (d *device) Tick(cycles uint64) {
+	d.cycles += cycles
+	d.calls++
+}
+
+func TestDevice(t *testing.T) {
+	// LDX #$03; loop: DEX; BNE loop; STA $0200
+	c, _ := newTest(0xa2, 0x03, 0xca, 0xd0, 0xfd, 0x8d, 0x00, 0x02)
+	var n ticks
+	var d device
+	c.T = &n
+	c.Dev = &d
+	for c.PC != 0x0608 {
+		c.Step()
+	}
+	if d.calls != 8 {
+		t.Errorf("got %d device calls, expected 8", d.calls)
+	}
+	if d.cycles != uint64(n) || n != 2+3*2+2*3+2+4 {
+		t.Errorf("device got %d cycles, cpu ran %d", d.cycles, n)
+	}
+}
+
+func TestSetNZ(t *testing.T) {
+	tests := []struct {
+		name  string
+		prog  []byte
+		setup func(c *Cpu, r Ram, v byte)
+		reg   func(c *Cpu, r Ram) byte // result
+	}{
+		{"LDA", []byte{0xa9}, nil, func(c *Cpu, r Ram) byte { return c.A }},
+		{"LDX", []byte{0xa2}, nil, func(c *Cpu, r Ram) byte { return c.X }},
+		{"LDY", []byte{0xa0}, nil, func(c *Cpu, r Ram) byte { return c.Y }},
+		{"TAX", []byte{0xaa}, func(c *Cpu, r Ram, v byte) { c.A = v }, func(c *Cpu, r Ram) byte { return c.X }},
+		{"TAY", []byte{0xa8}, func(c *Cpu, r Ram, v byte) { c.A = v }, func(c *Cpu, r Ram) byte { return c.Y }},
+		{"TXA", []byte{0x8a}, func(c *Cpu, r Ram, v byte) { c.X = v }, func(c *Cpu, r Ram) byte { return c.A }},
+		{"TYA", []byte{0x98}, func(c *Cpu, r Ram, v byte) { c.Y = v }, func(c *Cpu, r Ram) byte { return c.A }},
+		{"TSX", []byte{0xba}, func(c *Cpu, r Ram, v byte) { c.S = v }, func(c *Cpu, r Ram) byte { return c.X }},
+		{"INX", []byte{0xe8}, func(c *Cpu, r Ram, v byte) { c.X = v - 1 }, func(c *Cpu, r Ram) byte { return c.X }},
+		{"INY", []byte{0xc8}, func(c *Cpu, r Ram, v byte) { c.Y = v - 1 }, func(c *Cpu, r Ram) byte { return c.Y }},
+		{"DEX", []byte{0xca}, func(c *Cpu, r Ram, v byte) { c.X = v + 1 }, func(c *Cpu, r Ram) byte { return c.X }},
+		{"DEY", []byte{0x88}, func(c *Cpu, r Ram, v byte) { c.Y = v + 1 }, func(c *Cpu, r Ram) byte { return c.Y }},
+		{"INC", []byte{0xe6, 0x10}, func(c *Cpu, r Ram, v byte) { r[0x10] = v - 1 }, func(c *Cpu, r Ram) byte { return r[0x10] }},
+		{"DEC", []byte{0xc6, 0x10}, func(c *Cpu, r Ram, v byte) { r[0x10] = v + 1 }, func(c *Cpu, r Ram) byte { return r[0x10] }},
+		{"AND", []byte{0x29, 0xff}, func(c *Cpu, r Ram, v byte) { c.A = v }, func(c *Cpu, r Ram) byte { return c.A }},
+		{"ORA", []byte{0x09, 0x00}, func(c *Cpu, r Ram, v byte) { c.A = v }, func(c *Cpu, r Ram) byte { return c.A }},
+		{"EOR", []byte{0x49, 0x00}, func(c *Cpu, r Ram, v byte) { c.A = v }, func(c *Cpu, r Ram) byte { return c.A }},
+		{"ADC", []byte{0x69, 0x00}, func(c *Cpu, r Ram, v byte) { c.A = v }, func(c *Cpu, r Ram) byte { return c.A }},
+		{"PLA", []byte{0x68}, func(c *Cpu, r Ram, v byte) { r[0x1ff] = v; c.S = 0xfe }, func(c *Cpu, r Ram) byte { return c.A }},
+	}
+	for _, test := range tests {
+		for _, v := range []byte{0x00, 0x01, 0x40, 0x7f, 0x80, 0xc3, 0xff} {
+			prog := test.prog
+			if len(prog) == 1 && test.setup == nil {
+				prog = append(prog, v)
+			}
+			c, r := newTest(prog...)
+			if test.setup != nil {
+				test.setup(c, r, v)
+			}
+			// Start with both flags wrong.
+			c.P = P_X
+			if v != 0 {
+				c.P |= P_Z
+			}
+			if v&0x80 == 0 {
+				c.P |= P_N
+			}
+			c.Step()
+			if got := test.reg(c, r); got != v {
+				t.Errorf("%s %02X: got result %02X", test.name, v, got)
+			}
+			if c.Z() != (v == 0) || c.N() != (v&0x80 != 0) {
+				t.Errorf("%s %02X: got Z=%v N=%v", test.name, v, c.Z(), c.N())
+			}
+		}
+	}
+}
+
+func TestExecutedMap(t *testing.T) {
+	// LDX #$02; loop: DEX; BNE loop; NOP
+	c, _ := newTest(0xa2, 0x02, 0xca, 0xd0, 0xfd, 0xea)
+	if c.ExecutedMap() != nil {
+		t.Fatal("expected nil map")
+	}
+	c.Coverage = true
+	for c.PC != 0x0606 {
+		c.Step()
+	}
+	m := c.ExecutedMap()
+	for a := uint16(0x05ff); a <= 0x0607; a++ {
+		expect := a == 0x0600 || a == 0x0602 || a == 0x0603 || a == 0x0605
+		if m[a] != expect {
+			t.Errorf("%04X: got %v, expected %v", a, m[a], expect)
+		}
+	}
+}
+
+func TestTrapPC(t *testing.T) {
+	// LDA #$00; BEQ fail; BRK; fail: JMP fail
+	c, _ := newTest(0xa9, 0x00, 0xf0, 0x01, 0x00, 0x4c, 0x05, 0x06)
+	c.SetTrapPC(0x0605)
+	if err := c.Run(); err != ErrTrap {
+		t.Fatalf("got %v, expected ErrTrap", err)
+	}
+	if c.PC != 0x0605 {
+		t.Fatalf("got PC=%04X", c.PC)
+	}
+	// LDA #$01; BEQ fail; BRK, which jumps to the 0 IRQ vector.
+	c, _ = newTest(0xa9, 0x01, 0xf0, 0x01, 0x00, 0x4c, 0x05, 0x06)
+	c.SetTrapPC(0x0605)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	regs := []struct {
+		name         string
+		imm, zp, abs byte
+		set          func(*Cpu, byte)
+	}{
+		{"A", 0xc9, 0xc5, 0xcd, func(c *Cpu, v byte) { c.A = v }},
+		{"X", 0xe0, 0xe4, 0xec, func(c *Cpu, v byte) { c.X = v }},
+		{"Y", 0xc0, 0xc4, 0xcc, func(c *Cpu, v byte) { c.Y = v }},
+	}
+	tests := []struct {
+		r, v    byte
+		c, z, n bool
+	}{
+		{0x40, 0x40, true, true, false},
+		{0x41, 0x40, true, false, false},
+		{0x40, 0x41, false, false, true},
+		// N is bit 7 of the 8-bit difference, whatever the carry.
+		{0x01, 0xff, false, false, false},
+		{0x80, 0x00, true, false, true},
+		{0x00, 0x00, true, true, false},
+	}
+	for _, reg := range regs {
+		for _, test := range tests {
+			progs := [][]byte{
+				{reg.imm, test.v},
+				{reg.zp, 0x10},
+				{reg.abs, 0x00, 0x20},
+			}
+			for _, prog := range progs {
+				c, r := newTest(prog...)
+				r[0x10], r[0x2000] = test.v, test.v
+				reg.set(c, test.r)
+				c.Step()
+				if c.C() != test.c || c.Z() != test.z || c.N() != test.n {
+					t.Errorf("%s %02X: %02X vs %02X: got C=%v Z=%v N=%v", reg.name, prog[0], test.r, test.v, c.C(), c.Z(), c.N())
+				}
+			}
+		}
+	}
+}
+
+func TestInterruptLines(t *testing.T) {
+	// CLI; loop: JMP loop
+	c, r := newTest(0x58, 0x4c, 0x01, 0x06)
+	copy(r[0x0700:], []byte{0xe6, 0x10, 0x40}) // INC $10; RTI
+	copy(r[0x0800:], []byte{0xe6, 0x11, 0x40}) // INC $11; RTI
+	r[IRQ+1] = 0x07
+	r[NMI+1] = 0x08
+	c.SetIRQLine(true)
+	for i := 0; i < 16; i++ {
+		c.Step()
+	}
+	// Each IRQ takes 3 steps: entry, INC, RTI.
+	if r[0x10] != 5 {
+		t.Fatalf("IRQ ran %d times, expected 5", r[0x10])
+	}
+	c.SetIRQLine(false)
+	c.TriggerNMI()
+	for i := 0; i < 16; i++ {
+		c.Step()
+	}
+	if r[0x10] != 5 || r[0x11] != 1 {
+		t.Fatalf("IRQ ran %d times, NMI %d, expected 5 and 1", r[0x10], r[0x11])
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	c, r := newTest()
+	r[IRQ+1] = 0x07
+	c.P = P_C | P_B
+	c.Interrupt()
+	if c.PC != 0x0700 || !c.I() {
+		t.Fatalf("got PC=%04X I=%v", c.PC, c.I())
+	}
+	if p := c.StackDump()[0]; p != P_C|P_X {
+		t.Errorf("pushed P=%08b, expected B clear and bit 5 set", p)
+	}
+}
+
+func TestImpliedAccumulator(t *testing.T) {
+	if m := Optable[0xe8].Mode; m != MODE_IMP {
+		t.Errorf("INX: got mode %d, expected MODE_IMP", m)
+	}
+	for _, op := range []byte{0x0a, 0x4a, 0x2a, 0x6a} {
+		if m := Optable[op].Mode; m != MODE_ACC {
+			t.Errorf("%02X: got mode %d, expected MODE_ACC", op, m)
+		}
+	}
+	c, _ := newTest(0xa9, 0x81, 0x0a) // LDA #$81; ASL A
+	c.Step()
+	c.Step()
+	if c.A != 0x02 || !c.C() {
+		t.Errorf("ASL A: got A=%02X C=%v", c.A, c.C())
+	}
+}
+
+// irqTicker asserts the IRQ line of c on cycle at.
+type irqTicker struct {
+	c     *Cpu
+	n, at int
+}
+
+func (t *irqTicker) Tick() {
+	t.n++
+	if t.n == t.at {
+		t.c.SetIRQLine(true)
+	}
+}
+
+func TestInterruptLatency(t *testing.T) {
+	// CLI; LDA $0200; NOP
+	c, r := newTest(0x58, 0xad, 0x00, 0x02, 0xea)
+	r[IRQ+1] = 0x07
+	it := &irqTicker{c: c, at: 4}
+	c.T = it
+	c.Step()
+	c.Step() // IRQ arrives during LDA, which completes
+	if c.PC != 0x0604 || it.n != 6 {
+		t.Fatalf("got PC=%04X after %d cycles, expected 0604 after 6", c.PC, it.n)
+	}
+	c.Step()
+	if c.PC != 0x0700 || it.n != 6+interruptCycles {
+		t.Fatalf("got PC=%04X after %d cycles, expected 0700 after %d", c.PC, it.n, 6+interruptCycles)
+	}
+}
+
+func TestSMC(t *testing.T) {
+	c, _ := newTest(
+		0xa9, 0xe8, // LDA #$E8 (INX)
+		0x8d, 0x06, 0x06, // STA $0606
+		0xea,             // NOP
+		0xea,             // NOP, replaced by INX
+		0x8d, 0x00, 0x06, // STA $0600
+	)
+	var got []uint16
+	c.SMC = func(addr uint16) { got = append(got, addr) }
+	for c.PC != 0x060a {
+		c.Step()
+	}
+	if c.X != 1 {
+		t.Errorf("modified instruction did not run")
+	}
+	if len(got) != 2 || got[0] != 0x0606 || got[1] != 0x0600 {
+		t.Errorf("got SMC at %04X, expected 0606 and 0600", got)
+	}
+}
+
+func TestCheckStores(t *testing.T) {
+	if err := checkStores(Opcodes); err != nil {
+		t.Fatal(err)
+	}
+	bad := []Instruction{{Name: "STA", F: STA, Imm: 0x89, TIM: _3}}
+	if err := checkStores(bad); err == nil {
+		t.Fatal("expected error for STA #imm")
+	}
+}
+
+func TestLoadProgram(t *testing.T) {
+	r := make(Ram, 0xffff+1)
+	c := New(r)
+	// LDA #$20; ASL A; STA $0300; BRK
+	if err := c.LoadProgram(0x0800, []byte{0xa9, 0x20, 0x0a, 0x8d, 0x00, 0x03, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if c.PC != 0x0800 {
+		t.Fatalf("PC is %04X, expected 0800", c.PC)
+	}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if r[0x0300] != 0x40 {
+		t.Fatalf("got %02X, expected 40", r[0x0300])
+	}
+}
+
+func TestLoadProgramWrap(t *testing.T) {
+	c, r := newTest()
+	if err := c.LoadProgram(0xfffe, []byte{1, 2, 3, 4}); err != ErrProgramSize {
+		t.Fatalf("got %v, expected ErrProgramSize", err)
+	}
+	if r[0xfffe] != 0 || r[0] != 0 || c.PC != 0x0600 {
+		t.Fatal("memory or PC changed")
+	}
+	if err := c.LoadProgram(0xfffe, []byte{1, 2}); err != nil || r[0xffff] != 2 {
+		t.Fatalf("got %v, expected program to fit", err)
+	}
+}
+
+// baseCycles is the base cycle count of each opcode, without page crossing or
+// branch penalties. Opcodes that jam the CPU are 0.
+var baseCycles = [0x100]int{
+	/*  0  1  2  3  4  5  6  7  8  9  A  B  C  D  E  F */
+	7, 6, 0, 8, 3, 3, 5, 5, 3, 2, 2, 2, 4, 4, 6, 6, // 0
+	2, 5, 0, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7, // 1
+	6, 6, 0, 8, 3, 3, 5, 5, 4, 2, 2, 2, 4, 4, 6, 6, // 2
+	2, 5, 0, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7, // 3
+	6, 6, 0, 8, 3, 3, 5, 5, 3, 2, 2, 2, 3, 4, 6, 6, // 4
+	2, 5, 0, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7, // 5
+	6, 6, 0, 8, 3, 3, 5, 5, 4, 2, 2, 2, 5, 4, 6, 6, // 6
+	2, 5, 0, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7, // 7
+	2, 6, 2, 6, 3, 3, 3, 3, 2, 2, 2, 2, 4, 4, 4, 4, // 8
+	2, 6, 0, 6, 4, 4, 4, 4, 2, 5, 2, 5, 5, 5, 5, 5, // 9
+	2, 6, 2, 6, 3, 3, 3, 3, 2, 2, 2, 2, 4, 4, 4, 4, // A
+	2, 5, 0, 5, 4, 4, 4, 4, 2, 4, 2, 4, 4, 4, 4, 4, // B
+	2, 6, 2, 8, 3, 3, 5, 5, 2, 2, 2, 2, 4, 4, 6, 6, // C
+	2, 5, 0, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7, // D
+	2, 6, 2, 8, 3, 3, 5, 5, 2, 2, 2, 2, 4, 4, 6, 6, // E
+	2, 5, 0, 8, 4, 4, 6, 6, 2, 4, 2, 7, 4, 4, 7, 7, // F
+}
+
+func TestBaseCycles(t *testing.T) {
+	for i, o := range Optable {
+		if o.filler {
+			continue
+		}
+		if o.T != baseCycles[i] {
+			t.Errorf("%02X %v: got %d cycles, expected %d", i, o, o.T, baseCycles[i])
+		}
+	}
+}
+
+func TestLogical(t *testing.T) {
+	tests := []struct {
+		name   string
+		prog   []byte
+		expect byte
+	}{
+		{"AND #imm", []byte{0xa9, 0x0f, 0x29, 0xf0}, 0x00},
+		{"AND zp", []byte{0xa9, 0x3c, 0x25, 0x10}, 0x14},
+		{"ORA abs", []byte{0xa9, 0x3c, 0x0d, 0x00, 0x02}, 0x7d},
+		{"ORA (zp),Y", []byte{0xa9, 0x3c, 0x11, 0x20}, 0x7d},
+		{"EOR #imm", []byte{0xa9, 0x3c, 0x49, 0xff}, 0xc3},
+		{"EOR abs,X", []byte{0xa9, 0x3c, 0x5d, 0x00, 0x02}, 0x69},
+	}
+	for _, test := range tests {
+		c, r := newTest(test.prog...)
+		r[0x10] = 0x55
+		r[0x0200] = 0x55
+		r[0x20], r[0x21] = 0x00, 0x02
+		c.Step()
+		c.Step()
+		if c.A != test.expect || c.Z() != (test.expect == 0) || c.N() != (test.expect&0x80 != 0) {
+			t.Errorf("%s: got A=%02X Z=%v N=%v, expected %02X", test.name, c.A, c.Z(), c.N(), test.expect)
+		}
+	}
+}
+
+func TestShifts(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       byte
+		in       byte
+		carry    bool
+		expect   byte
+		carryOut bool
+	}{
+		{"ASL", 0x0a, 0x81, false, 0x02, true},
+		{"LSR", 0x4a, 0x81, false, 0x40, true},
+		{"ROL", 0x2a, 0x40, true, 0x81, false},
+		{"ROR", 0x6a, 0x02, true, 0x81, false},
+	}
+	for _, test := range tests {
+		// Accumulator form, then zero page, zp,X, abs, and abs,X forms.
+		for _, m := range []struct {
+			off  byte
+			prog []byte
+		}{
+			{0x00, nil},
+			{0xfc, []byte{0x10}},
+			{0x0c, []byte{0x0f}},
+			{0x04, []byte{0x10, 0x00}},
+			{0x14, []byte{0x0f, 0x00}},
+		} {
+			c, r := newTest(append([]byte{test.op + m.off}, m.prog...)...)
+			c.X = 1
+			c.A, r[0x10] = test.in, test.in
+			if test.carry {
+				c.SEC()
+			}
+			c.Step()
+			got := r[0x10]
+			if m.off == 0 {
+				got = c.A
+			}
+			if got != test.expect || c.C() != test.carryOut || c.N() != (test.expect&0x80 != 0) || c.Z() {
+				t.Errorf("%s %02X: got %02X C=%v N=%v Z=%v", test.name, test.op+m.off, got, c.C(), c.N(), c.Z())
+			}
+		}
+	}
+}
+
+func TestStackOps(t *testing.T) {
+	// LDA #$80; PHA; PHP; LDA #$00; PLP; PLA
+	c, r := newTest(0xa9, 0x80, 0x48, 0x08, 0xa9, 0x00, 0x28, 0x68)
+	c.S = 0x00
+	c.P = P_C
+	c.Step()
+	c.Step()
+	c.Step()
+	// The push at S=0 wraps to 0x01ff.
+	if c.S != 0xfe || r[0x0100] != 0x80 || r[0x01ff] != P_C|P_X|P_B|P_N {
+		t.Fatalf("got S=%02X stack %02X %02X", c.S, r[0x0100], r[0x01ff])
+	}
+	c.Step()
+	c.Step()
+	if c.P != P_C|P_X|P_N {
+		t.Errorf("PLP: got P=%08b, expected B clear and bit 5 set", c.P)
+	}
+	c.Step()
+	if c.A != 0x80 || c.S != 0x00 || !c.N() || c.Z() {
+		t.Errorf("PLA: got A=%02X S=%02X N=%v Z=%v", c.A, c.S, c.N(), c.Z())
+	}
+}
+
+func TestJSRNested(t *testing.T) {
+	// JSR $0700; NOP
+	c, r := newTest(0x20, 0x00, 0x07, 0xea)
+	copy(r[0x0700:], []byte{0x20, 0x00, 0x08, 0x60}) // JSR $0800; RTS
+	r[0x0800] = 0x60                                 // RTS
+	c.Step()
+	if c.PC != 0x0700 || r[0x01ff] != 0x06 || r[0x01fe] != 0x02 {
+		t.Fatalf("got PC=%04X, pushed %02X%02X, expected 0602", c.PC, r[0x01ff], r[0x01fe])
+	}
+	c.Step()
+	if c.PC != 0x0800 || r[0x01fd] != 0x07 || r[0x01fc] != 0x02 {
+		t.Fatalf("got PC=%04X, pushed %02X%02X, expected 0702", c.PC, r[0x01fd], r[0x01fc])
+	}
+	c.Step()
+	if c.PC != 0x0703 {
+		t.Fatalf("inner RTS: got PC=%04X, expected 0703", c.PC)
+	}
+	c.Step()
+	if c.PC != 0x0603 || c.S != 0xff {
+		t.Fatalf("outer RTS: got PC=%04X S=%02X, expected 0603 FF", c.PC, c.S)
+	}
+}
+
+func TestBRKRTI(t *testing.T) {
+	// BRK; padding; NOP
+	c, r := newTest(0x00, 0xff, 0xea)
+	r[IRQ+1] = 0x07
+	r[0x0700] = 0x40 // RTI
+	c.P = P_C
+	c.Step()
+	if c.PC != 0x0700 || !c.I() {
+		t.Fatalf("got PC=%04X I=%v", c.PC, c.I())
+	}
+	if s := c.StackDump(); len(s) != 3 || s[0] != P_C|P_B|P_X || s[1] != 0x02 || s[2] != 0x06 {
+		t.Fatalf("got stack % X, expected P with B set and 0602", s)
+	}
+	c.Step()
+	if c.PC != 0x0602 || c.P != P_C|P_X || c.S != 0xff {
+		t.Fatalf("RTI: got PC=%04X P=%08b S=%02X", c.PC, c.P, c.S)
+	}
+}
+
+func TestHaltOnBRK(t *testing.T) {
+	// LDA #$01; BRK; padding; LDA #$02
+	prog := []byte{0xa9, 0x01, 0x00, 0xff, 0xa9, 0x02}
+	c, r := newTest(prog...)
+	r[IRQ], r[IRQ+1] = 0x04, 0x06
+	c.Step()
+	c.Step()
+	if c.PC != 0x0604 || c.S != 0xfc {
+		t.Fatalf("BRK: got PC=%04X S=%02X, expected the IRQ vector", c.PC, c.S)
+	}
+	c, r = newTest(prog...)
+	r[IRQ], r[IRQ+1] = 0x04, 0x06
+	c.HaltOnBRK = true
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if c.PC != 0 || c.A != 0x01 || c.S != 0xff {
+		t.Fatalf("HaltOnBRK: got PC=%04X A=%02X S=%02X", c.PC, c.A, c.S)
+	}
+}
+
+func TestIncDec(t *testing.T) {
+	// INC $10; DEC $0200,X; INY; DEY; DEY
+	c, r := newTest(0xe6, 0x10, 0xde, 0x00, 0x02, 0xc8, 0x88, 0x88)
+	r[0x10] = 0xff
+	c.X = 1
+	c.Step()
+	if r[0x10] != 0x00 || !c.Z() || c.N() {
+		t.Fatalf("INC: got %02X Z=%v N=%v", r[0x10], c.Z(), c.N())
+	}
+	c.Step()
+	if r[0x0201] != 0xff || c.Z() || !c.N() {
+		t.Fatalf("DEC: got %02X Z=%v N=%v", r[0x0201], c.Z(), c.N())
+	}
+	c.Step()
+	c.Step()
+	if c.Y != 0 || !c.Z() {
+		t.Fatalf("INY, DEY: got Y=%02X Z=%v", c.Y, c.Z())
+	}
+	c.Step()
+	if c.Y != 0xff || !c.N() {
+		t.Fatalf("DEY: got Y=%02X N=%v", c.Y, c.N())
+	}
+}
+
+func TestTXS(t *testing.T) {
+	// TXS; TSX
+	c, _ := newTest(0x9a, 0xba)
+	c.X = 0x80
+	c.P = P_X | P_Z
+	c.Step()
+	if c.S != 0x80 || c.P != P_X|P_Z {
+		t.Fatalf("TXS: got S=%02X P=%08b, expected flags unchanged", c.S, c.P)
+	}
+	c.X = 0
+	c.Step()
+	if c.X != 0x80 || c.Z() || !c.N() {
+		t.Fatalf("TSX: got X=%02X Z=%v N=%v", c.X, c.Z(), c.N())
+	}
+}
+
+func TestFlagOps(t *testing.T) {
+	tests := []struct {
+		set, clear byte
+		get        func(c *Cpu) bool
+	}{
+		{0x38, 0x18, (*Cpu).C}, // SEC, CLC
+		{0xf8, 0xd8, (*Cpu).D}, // SED, CLD
+		{0x78, 0x58, (*Cpu).I}, // SEI, CLI
+	}
+	for _, test := range tests {
+		c, _ := newTest(test.set, test.clear)
+		c.P = 0
+		c.Step()
+		if !test.get(c) {
+			t.Errorf("%02X did not set its flag", test.set)
+		}
+		c.Step()
+		if test.get(c) || c.P != 0 {
+			t.Errorf("%02X did not clear its flag: P=%08b", test.clear, c.P)
+		}
+	}
+	c, _ := newTest(0xb8) // CLV
+	c.P = P_V | P_C
+	c.Step()
+	if c.V() || !c.C() {
+		t.Errorf("CLV: got P=%08b", c.P)
+	}
+}
+
+func TestSBC(t *testing.T) {
+	tests := []struct {
+		a, b, expect byte
+		carry, c, v  bool
+	}{
+		{0x50, 0xf0, 0x60, true, false, false},
+		{0x50, 0xb0, 0xa0, true, false, true},
+		{0xd0, 0x70, 0x60, true, true, true},
+		{0xd0, 0x30, 0xa0, true, true, false},
+		{0x50, 0x10, 0x40, true, true, false},
+		{0x50, 0x70, 0xe0, true, false, false},
+		{0x00, 0x00, 0xff, false, false, false},
+		{0x80, 0x00, 0x7f, false, true, true},
+	}
+	for _, test := range tests {
+		// SBC #b
+		c, _ := newTest(0xe9, test.b)
+		c.A = test.a
+		if test.carry {
+			c.SEC()
+		}
+		c.Step()
+		if c.A != test.expect || c.C() != test.c || c.V() != test.v {
+			t.Errorf("%02X-%02X C=%v: got %02X C=%v V=%v, expected %02X C=%v V=%v", test.a, test.b, test.carry, c.A, c.C(), c.V(), test.expect, test.c, test.v)
+		}
+	}
+}
+
+func TestBIT(t *testing.T) {
+	// BIT $10; BIT $0200
+	c, r := newTest(0x24, 0x10, 0x2c, 0x00, 0x02)
+	r[0x10] = 0x40
+	r[0x0200] = 0x81
+	c.A = 0x01
+	c.Step()
+	if !c.V() || c.N() || !c.Z() || c.A != 0x01 {
+		t.Fatalf("BIT zp: got V=%v N=%v Z=%v A=%02X", c.V(), c.N(), c.Z(), c.A)
+	}
+	c.Step()
+	if c.V() || !c.N() || c.Z() || c.A != 0x01 {
+		t.Fatalf("BIT abs: got V=%v N=%v Z=%v A=%02X", c.V(), c.N(), c.Z(), c.A)
+	}
+}
+
+func TestUndocumentedNOP(t *testing.T) {
+	tests := []struct {
+		prog   []byte
+		cycles int
+	}{
+		{[]byte{0x1a}, 2},
+		{[]byte{0xfa}, 2},
+		{[]byte{0x80, 0xff}, 2},
+		{[]byte{0x04, 0x10}, 3},
+		{[]byte{0x44, 0x10}, 3},
+		{[]byte{0x64, 0x10}, 3},
+		{[]byte{0x14, 0x10}, 4},
+		{[]byte{0x0c, 0x00, 0x02}, 4},
+		{[]byte{0x1c, 0x00, 0x02}, 4},
+	}
+	for _, test := range tests {
+		c, r := newTest(test.prog...)
+		before := append(Ram(nil), r...)
+		reg := c.Register
+		c.Step()
+		reg.PC += uint16(len(test.prog))
+		if c.Register != reg || c.stepCycles != test.cycles {
+			t.Errorf("%02X: got %+v in %d cycles, expected %+v in %d", test.prog[0], c.Register, c.stepCycles, reg, test.cycles)
+		}
+		if d := DiffMem(before, r); d != nil {
+			t.Errorf("%02X: wrote memory: %v", test.prog[0], d)
+		}
+	}
+}
+
+func TestZeroPageXWrap(t *testing.T) {
+	// LDA $FF,X; STA $FF,X
+	c, r := newTest(0xb5, 0xff, 0x95, 0xff)
+	r[0x01] = 0x42
+	r[0x0101] = 0x99
+	c.X = 2
+	c.Step()
+	if c.A != 0x42 {
+		t.Fatalf("LDA $FF,X: got %02X, expected the byte at 0x01", c.A)
+	}
+	r[0x01] = 0
+	c.Step()
+	if r[0x01] != 0x42 || r[0x0101] != 0x99 {
+		t.Fatal("STA $FF,X did not write 0x01")
+	}
+}
+
+func TestZeroPageYWrap(t *testing.T) {
+	// LDX $80,Y; STX $F0,Y
+	c, r := newTest(0xb6, 0x80, 0x96, 0xf0)
+	r[0x10] = 0x42
+	r[0x0110] = 0x99
+	c.Y = 0x90
+	c.Step()
+	if c.X != 0x42 {
+		t.Fatalf("LDX $80,Y: got %02X, expected the byte at 0x10", c.X)
+	}
+	c.Step()
+	if r[0x80] != 0x42 || r[0x0180] != 0 {
+		t.Fatal("STX $F0,Y did not write 0x80")
+	}
+}
+
+func TestAbsoluteIndexedPageCross(t *testing.T) {
+	// LDA $12FF,X; LDA $12FF,Y; STA $12FF,X
+	c, r := newTest(0xbd, 0xff, 0x12, 0xb9, 0xff, 0x12, 0x9d, 0xff, 0x12)
+	r[0x1300] = 0x42
+	r[0x1301] = 0x43
+	c.X, c.Y = 1, 2
+	c.Step()
+	if c.A != 0x42 {
+		t.Fatalf("LDA $12FF,X: got %02X", c.A)
+	}
+	c.Step()
+	if c.A != 0x43 {
+		t.Fatalf("LDA $12FF,Y: got %02X", c.A)
+	}
+	r[0x1300] = 0
+	c.Step()
+	if r[0x1300] != 0x43 {
+		t.Fatal("STA $12FF,X did not write 0x1300")
+	}
+}
+
+func TestIndirectIndexedWrap(t *testing.T) {
+	// LDA ($FF),Y
+	c, r := newTest(0xb1, 0xff)
+	r[0xff] = 0x00
+	r[0x00] = 0x03 // high byte wraps to 0x00, not 0x0100
+	r[0x0100] = 0x04
+	r[0x0305] = 0x42
+	c.Y = 5
+	c.Step()
+	if c.A != 0x42 {
+		t.Fatalf("got %02X, expected the byte at 0x0305", c.A)
+	}
+}
+
+func TestIndexedIndirectWrap(t *testing.T) {
+	// LDA ($FE,X)
+	c, r := newTest(0xa1, 0xfe)
+	r[0xff] = 0x05
+	r[0x00] = 0x03 // high byte wraps to 0x00, not 0x0100
+	r[0x0100] = 0x04
+	r[0x0305] = 0x42
+	c.X = 1
+	c.Step()
+	if c.A != 0x42 {
+		t.Fatalf("got %02X, expected the byte at 0x0305", c.A)
+	}
+}
+
+func TestJMPIndirectBug(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		// JMP ($30FF)
+		c, r := newTest(0x6c, 0xff, 0x30)
+		r[0x30ff] = 0x80
+		r[0x3000] = 0x12
+		r[0x3100] = 0x34
+		c.DisableJMPBug = disable
+		expect := uint16(0x1280)
+		if disable {
+			expect = 0x3480
+		}
+		if taken, _, _ := c.NextPC(); taken != expect {
+			t.Errorf("DisableJMPBug=%v: NextPC got %04X, expected %04X", disable, taken, expect)
+		}
+		c.Step()
+		if c.PC != expect {
+			t.Errorf("DisableJMPBug=%v: got PC=%04X, expected %04X", disable, c.PC, expect)
+		}
+	}
+}
+
+func TestStoreModes(t *testing.T) {
+	tests := []struct {
+		name string
+		prog []byte
+		addr uint16
+	}{
+		{"STA zp", []byte{0x85, 0x10}, 0x0010},
+		{"STA zp,X", []byte{0x95, 0x10}, 0x0012},
+		{"STA abs", []byte{0x8d, 0x00, 0x02}, 0x0200},
+		{"STA abs,X", []byte{0x9d, 0x00, 0x02}, 0x0202},
+		{"STA abs,Y", []byte{0x99, 0x00, 0x02}, 0x0203},
+		{"STA (zp,X)", []byte{0x81, 0x20}, 0x0300},
+		{"STA (zp),Y", []byte{0x91, 0x24}, 0x0403},
+		{"STX zp,Y", []byte{0x96, 0x10}, 0x0013},
+		{"STY zp,X", []byte{0x94, 0x10}, 0x0012},
+	}
+	for _, test := range tests {
+		c, r := newTest(test.prog...)
+		c.A, c.X, c.Y = 0x42, 2, 3
+		if test.name[:3] == "STX" {
+			c.X = 0x42
+		} else if test.name[:3] == "STY" {
+			c.Y = 0x42
+			c.X = 2
+		}
+		r[0x22], r[0x23] = 0x00, 0x03 // for (zp,X)
+		r[0x24], r[0x25] = 0x00, 0x04 // for (zp),Y
+		before := append(Ram(nil), r...)
+		c.Step()
+		d := DiffMem(before, r)
+		if len(d) != 1 || d[0].Addr != test.addr || string(d[0].New) != "\x42" {
+			t.Errorf("%s: got writes %+v, expected 42 at %04X", test.name, d, test.addr)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	c, r := newTest()
+	r[RESET], r[RESET+1] = 0x34, 0x12
+	c.S = 0x10
+	c.P = P_D | P_C
+	c.Reset()
+	if c.PC != 0x1234 || c.S != 0xfd || !c.I() || c.D() || !c.C() {
+		t.Fatalf("got PC=%04X S=%02X P=%08b", c.PC, c.S, c.P)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	c, _ := newTest()
+	for p := 0; p <= 0xff; p++ {
+		c.P = byte(p) | P_X
+		c.SetFlags(c.Flags())
+		if c.P != byte(p)|P_X {
+			t.Fatalf("%08b: round trip got %08b", p|int(P_X), c.P)
+		}
+	}
+	c.SetFlags(Flags{N: true, C: true})
+	if c.P != P_N|P_X|P_C {
+		t.Fatalf("got P=%08b", c.P)
+	}
+	if f := c.Flags(); f != (Flags{N: true, C: true}) {
+		t.Fatalf("got %+v", f)
+	}
+}
+
+func TestPushStatus(t *testing.T) {
+	c, r := newTest(0x00, 0x00) // BRK
+	r[IRQ+1] = 0x07
+	if c.B() {
+		t.Fatal("New set B")
+	}
+	c.Step()
+	if p := c.StackDump()[0]; p&P_B == 0 || p&P_X == 0 {
+		t.Errorf("BRK pushed %08b, expected B and bit 5 set", p)
+	}
+	c, r = newTest()
+	r[IRQ+1] = 0x07
+	c.SetIRQLine(true)
+	c.CLI()
+	c.Step()
+	if p := c.StackDump()[0]; p&P_B != 0 || p&P_X == 0 {
+		t.Errorf("IRQ pushed %08b, expected B clear and bit 5 set", p)
+	}
+	if c.B() {
+		t.Error("B set in P")
+	}
+}
+
+func TestStackWrap(t *testing.T) {
+	c, r := newTest()
+	c.S = 0x00
+	c.push8(0x11)
+	if r[0x0100] != 0x11 || c.S != 0xff {
+		t.Fatalf("push8 at S=00: got S=%02X", c.S)
+	}
+	if b := c.pull8(); b != 0x11 || c.S != 0x00 {
+		t.Fatalf("pull8: got %02X S=%02X", b, c.S)
+	}
+	c.push16(0x1234)
+	if r[0x0100] != 0x12 || r[0x01ff] != 0x34 || c.S != 0xfe {
+		t.Fatalf("push16 at S=00: got %02X %02X S=%02X", r[0x0100], r[0x01ff], c.S)
+	}
+	if v := c.pull16(); v != 0x1234 || c.S != 0x00 {
+		t.Fatalf("pull16: got %04X S=%02X", v, c.S)
+	}
+}
+
+func TestIRQ(t *testing.T) {
+	c, r := newTest()
+	r[IRQ], r[IRQ+1] = 0x00, 0x07
+	c.SEI()
+	c.IRQ()
+	if c.PC != 0x0600 || c.S != 0xff {
+		t.Fatalf("masked IRQ: got PC=%04X S=%02X", c.PC, c.S)
+	}
+	c.CLI()
+	c.IRQ()
+	if c.PC != 0x0700 || !c.I() {
+		t.Fatalf("got PC=%04X I=%v", c.PC, c.I())
+	}
+	if s := c.StackDump(); len(s) != 3 || s[0] != P_X || s[1] != 0x00 || s[2] != 0x06 {
+		t.Fatalf("got stack % X", s)
+	}
+}
+
+func TestNMI(t *testing.T) {
+	c, r := newTest()
+	r[NMI], r[NMI+1] = 0x34, 0x12
+	c.SEI()
+	c.NMI()
+	if c.PC != 0x1234 || c.stepCycles != interruptCycles {
+		t.Fatalf("got PC=%04X in %d cycles", c.PC, c.stepCycles)
+	}
+	if s := c.StackDump(); len(s) != 3 || s[0]&P_B != 0 || s[1] != 0x00 || s[2] != 0x06 {
+		t.Fatalf("got stack % X", s)
+	}
+}
+
+func TestCycles(t *testing.T) {
+	// LDA #$01; TAX; INX
+	c, _ := newTest(0xa9, 0x01, 0xaa, 0xe8)
+	for i := 0; i < 3; i++ {
+		c.Step()
+	}
+	if c.Cycles != 2+2+2 {
+		t.Fatalf("got %d cycles, expected 6", c.Cycles)
+	}
+}
+
+func TestPageCrossCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		prog   []byte
+		cycles int
+	}{
+		{"LDA abs,X", []byte{0xbd, 0x00, 0x12}, 4},
+		{"LDA abs,X across", []byte{0xbd, 0xff, 0x12}, 5},
+		{"LDA abs,Y across", []byte{0xb9, 0xff, 0x12}, 5},
+		{"LDA (zp),Y", []byte{0xb1, 0x10}, 5},
+		{"LDA (zp),Y across", []byte{0xb1, 0x20}, 6},
+		{"STA abs,X across", []byte{0x9d, 0xff, 0x12}, 5},
+		{"INC abs,X across", []byte{0xfe, 0xff, 0x12}, 7},
+		{"NOP abs,X across", []byte{0x1c, 0xff, 0x12}, 5},
+	}
+	for _, test := range tests {
+		c, r := newTest(test.prog...)
+		c.X, c.Y = 1, 1
+		r[0x10], r[0x11] = 0x00, 0x12
+		r[0x20], r[0x21] = 0xff, 0x12
+		c.Step()
+		if c.stepCycles != test.cycles {
+			t.Errorf("%s: got %d cycles, expected %d", test.name, c.stepCycles, test.cycles)
+		}
+	}
+}
+
+func TestBranchCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		pc     uint16
+		z      bool
+		target uint16
+		cycles int
+	}{
+		{"not taken", 0x0600, true, 0x0602, 2},
+		{"taken", 0x0600, false, 0x0612, 3},
+		{"taken within a page", 0x06e0, false, 0x06f2, 3},
+		{"taken to the next page", 0x06f0, false, 0x0702, 4},
+	}
+	for _, test := range tests {
+		r := make(Ram, 0xffff+1)
+		r[test.pc], r[test.pc+1] = 0xd0, 0x10 // BNE +16
+		c := New(r)
+		c.PC = test.pc
+		if test.z {
+			c.P |= P_Z
+		}
+		c.Step()
+		if c.PC != test.target || c.stepCycles != test.cycles {
+			t.Errorf("%s: got PC=%04X in %d cycles, expected %04X in %d", test.name, c.PC, c.stepCycles, test.target, test.cycles)
+		}
+	}
+}
+
+func TestRunUntil(t *testing.T) {
+	// loop: INC $10; JMP loop
+	c, _ := newTest(0xe6, 0x10, 0x4c, 0x00, 0x06)
+	if err := c.RunUntil(100); err != nil {
+		t.Fatal(err)
+	}
+	// Each pass takes 5+3 cycles, so no instruction ends exactly at 100.
+	if c.Cycles < 100 || c.Cycles >= 100+5 {
+		t.Fatalf("stopped at %d cycles", c.Cycles)
+	}
+	c.RunUntil(50)
+	if c.Cycles >= 100+5 {
+		t.Fatalf("ran past an earlier target: %d cycles", c.Cycles)
+	}
+}
+
+func TestADCDecimal(t *testing.T) {
+	tests := []struct {
+		a, b, expect byte
+		carry, c     bool
+	}{
+		{0x09, 0x01, 0x10, false, false},
+		{0x99, 0x01, 0x00, false, true},
+		{0x58, 0x46, 0x05, true, true},
+		{0x12, 0x34, 0x46, false, false},
+		{0x15, 0x26, 0x42, true, false},
+	}
+	for _, test := range tests {
+		// ADC #b
+		c, _ := newTest(0x69, test.b)
+		c.DecimalEnabled = true
+		c.A = test.a
+		c.SED()
+		if test.carry {
+			c.SEC()
+		}
+		c.Step()
+		if c.A != test.expect || c.C() != test.c {
+			t.Errorf("%02X+%02X C=%v: got %02X C=%v, expected %02X C=%v", test.a, test.b, test.carry, c.A, c.C(), test.expect, test.c)
+		}
+	}
+}
+
+func TestSBCDecimal(t *testing.T) {
+	tests := []struct {
+		a, b, expect, binary byte
+		c                    bool
+	}{
+		{0x10, 0x01, 0x09, 0x0f, true},
+		{0x46, 0x12, 0x34, 0x34, true},
+		{0x00, 0x01, 0x99, 0xff, false},
+		{0x32, 0x02, 0x30, 0x30, true},
+	}
+	for _, test := range tests {
+		for _, enable := range []bool{false, true} {
+			// SBC #b
+			c, _ := newTest(0xe9, test.b)
+			c.DecimalEnabled = enable
+			c.A = test.a
+			c.SED()
+			c.SEC()
+			c.Step()
+			expect := test.binary
+			if enable {
+				expect = test.expect
+			}
+			if c.A != expect || c.C() != test.c {
+				t.Errorf("%02X-%02X DecimalEnabled=%v: got %02X C=%v, expected %02X C=%v", test.a, test.b, enable, c.A, c.C(), expect, test.c)
+			}
+		}
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	// LDA #$01; LDX #$02; LDY #$03
+	c, _ := newTest(0xa9, 0x01, 0xa2, 0x02, 0xa0, 0x03)
+	for i := 0; i < 3; i++ {
+		c.Step()
+	}
+	c.S = 0xf0
+	r := c.Registers()
+	expect := RegisterState{A: 1, X: 2, Y: 3, S: 0xf0, P: c.P, PC: 0x0606, Cycles: 6}
+	if r != expect {
+		t.Errorf("got %+v, expected %+v", r, expect)
+	}
+	c.A = 0x10
+	if r.A != 1 {
+		t.Error("snapshot changed with the Cpu")
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	// LDX #$00; loop: INX; CPX #$03; BNE loop; LDA #$AA
+	c, _ := newTest(0xa2, 0x00, 0xe8, 0xe0, 0x03, 0xd0, 0xfb, 0xa9, 0xaa)
+	c.SetBreakpoint(0x0605)
+	for i := 1; i <= 3; i++ {
+		hit, halted, err := c.RunUntilBreak()
+		if err != nil || halted || hit != 0x0605 {
+			t.Fatalf("got %04X, %v, %v, expected 0605", hit, halted, err)
+		}
+		if c.X != byte(i) {
+			t.Errorf("X is %d, expected %d", c.X, i)
+		}
+	}
+	c.ClearBreakpoint(0x0605)
+	c.SetBreakpoint(0x0609)
+	if hit, halted, _ := c.RunUntilBreak(); halted || hit != 0x0609 || c.A != 0xaa || c.X != 3 {
+		t.Errorf("got %04X, %v, A=%02X X=%d", hit, halted, c.A, c.X)
+	}
+}
+
+func TestWatchWrite(t *testing.T) {
+	// LDA #$0F; STA $4015; INC $4015; STA $4000
+	c, r := newTest(0xa9, 0x0f, 0x8d, 0x15, 0x40, 0xee, 0x15, 0x40, 0x8d, 0x00, 0x40)
+	r[0x4015] = 0x01
+	type write struct {
+		addr     uint16
+		old, new byte
+	}
+	var got []write
+	c.WatchWrite(0x4015, func(addr uint16, old, new byte) {
+		got = append(got, write{addr, old, new})
+	})
+	for i := 0; i < 4; i++ {
+		c.Step()
+	}
+	expect := []write{{0x4015, 0x01, 0x0f}, {0x4015, 0x0f, 0x10}}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, expected %v", got, expect)
+	}
+}
+
+// busLog is a Memory that records each access.
+type busLog struct {
+	Ram
+	log []string
+}
+
+func (b *busLog) Read(v uint16) byte {
+	b.log = append(b.log, fmt.Sprintf("R %04X", v))
+	return b.Ram[v]
+}
+
+func (b *busLog) Write(v uint16, x byte) {
+	b.log = append(b.log, fmt.Sprintf("W %04X %02X", v, x))
+	b.Ram[v] = x
+}
+
+func TestMemoryAccesses(t *testing.T) {
+	b := &busLog{Ram: make(Ram, 0xffff+1)}
+	// LDA $10; STA $4000
+	copy(b.Ram[0x0600:], []byte{0xa5, 0x10, 0x8d, 0x00, 0x40})
+	b.Ram[0x10] = 0x3f
+	c := New(b)
+	c.PC = 0x0600
+	c.Step()
+	c.Step()
+	// Each of these must appear, in order.
+	expect := []string{"R 0600", "R 0601", "R 0010", "R 0602", "R 0603", "R 0604", "W 4000 3F"}
+	i := 0
+	for _, l := range b.log {
+		if i < len(expect) && l == expect[i] {
+			i++
+		} else if strings.HasPrefix(l, "W") {
+			t.Fatalf("unexpected write %s in %q", l, b.log)
+		}
+	}
+	if i != len(expect) {
+		t.Fatalf("missing %s in %q", expect[i], b.log)
+	}
+}
+
+func TestUnknownOpcode(t *testing.T) {
+	// LDA #$01; .byte $02, $00; LDA #$02
+	c, _ := newTest(0xa9, 0x01, 0x02, 0x00, 0xa9, 0x02)
+	if err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Step()
+	e, ok := err.(*UnknownOpcodeError)
+	if !ok {
+		t.Fatalf("got %v, expected *UnknownOpcodeError", err)
+	}
+	if e.Opcode != 0x02 || e.PC != 0x0602 {
+		t.Errorf("got %+v", e)
+	}
+	if err := c.Run(); err != nil {
+		t.Errorf("got %v after the unknown opcode", err)
+	}
+	if c.A != 0x02 {
+		t.Errorf("A is %02X, expected 02", c.A)
+	}
+	c.PC = 0x0602
+	if err, ok := c.Run().(*UnknownOpcodeError); !ok || *err != *e {
+		t.Errorf("Run returned %v", err)
+	}
+	c.PC = 0x0602
+	if err := c.RunUntil(c.Cycles + 100); err == nil || c.PC != 0x0604 {
+		t.Errorf("RunUntil returned %v at %04X", err, c.PC)
+	}
+	c.PC = 0x0602
+	if n, err := c.RunCycles(100); err == nil || n != 2 || c.PC != 0x0604 {
+		t.Errorf("RunCycles returned %d, %v at %04X", n, err, c.PC)
+	}
+	c.PC = 0x0602
+	c.SetBreakpoint(0x0606)
+	if hit, halted, err := c.RunUntilBreak(); err == nil || halted || hit != 0x0604 {
+		t.Errorf("RunUntilBreak returned %04X, %v, %v", hit, halted, err)
+	}
+}
+
+func TestUnofficial(t *testing.T) {
+	tests := []struct {
+		name    string
+		prog    []byte
+		a, x, m byte
+		p       byte // N, Z, and C
+	}{
+		// LDA #$F0; LDX #$3C; LAX $10
+		{"LAX", []byte{0xa9, 0xf0, 0xa2, 0x3c, 0xa7, 0x10}, 0x80, 0x80, 0x80, P_N},
+		// LDA #$F0; LDX #$3C; SAX $10
+		{"SAX", []byte{0xa9, 0xf0, 0xa2, 0x3c, 0x87, 0x10}, 0xf0, 0x3c, 0x30, 0},
+		// LDA #$7F; LDX #$00; DCP $10
+		{"DCP", []byte{0xa9, 0x7f, 0xa2, 0x00, 0xc7, 0x10}, 0x7f, 0x00, 0x7f, P_Z | P_C},
+		// LDA #$90; LDX #$00; SEC; ISC $10
+		{"ISC", []byte{0xa9, 0x90, 0xa2, 0x00, 0x38, 0xe7, 0x10}, 0x0f, 0x00, 0x81, P_C},
+	}
+	for _, test := range tests {
+		c, r := newTest(test.prog...)
+		c.AllowIllegal = true
+		r[0x10] = 0x80
+		for c.PC < 0x0600+uint16(len(test.prog)) {
+			if err := c.Step(); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		if p := c.P & (P_N | P_Z | P_C); c.A != test.a || c.X != test.x || r[0x10] != test.m || p != test.p {
+			t.Errorf("%s: got A=%02X X=%02X M=%02X P=%08b, expected A=%02X X=%02X M=%02X P=%08b", test.name, c.A, c.X, r[0x10], p, test.a, test.x, test.m, test.p)
+		}
+	}
+}
+
+func TestAllowIllegal(t *testing.T) {
+	// LAX $10; ISC $0200,X; NOP $10
+	c, r := newTest(0xa7, 0x10, 0xff, 0x00, 0x02, 0x04, 0x10)
+	r[0x10] = 0x42
+	err := c.Step()
+	if e, ok := err.(*UnknownOpcodeError); !ok || e.Opcode != 0xa7 {
+		t.Fatalf("got %v, expected unknown opcode A7", err)
+	}
+	if c.A != 0 || c.X != 0 || c.PC != 0x0602 || c.stepCycles != 3 {
+		t.Errorf("LAX ran: A=%02X X=%02X PC=%04X in %d cycles", c.A, c.X, c.PC, c.stepCycles)
+	}
+	err = c.Step()
+	if e, ok := err.(*UnknownOpcodeError); !ok || e.Opcode != 0xff || e.PC != 0x0602 {
+		t.Fatalf("got %v, expected unknown opcode FF at 0602", err)
+	}
+	if r[0x0200] != 0 {
+		t.Error("ISC wrote memory")
+	}
+	// The unofficial NOPs run without AllowIllegal.
+	if err := c.Step(); err != nil || c.PC != 0x0607 {
+		t.Errorf("NOP $10: got %v at %04X", err, c.PC)
+	}
+	// Only the returned error is allocated.
+	if n := testing.AllocsPerRun(100, func() { c.PC = 0x0600; c.Step() }); n != 1 {
+		t.Errorf("a gated opcode allocates %v times per run, expected 1", n)
+	}
+}
+
+func TestCall(t *testing.T) {
+	// LDA #$01; RTS
+	c, _ := newTest(0xa9, 0x01, 0x60)
+	s := c.S
+	c.Call(0x0600)
+	if c.PC != 0x0600 || c.S != s-2 {
+		t.Fatalf("got PC=%04X S=%02X", c.PC, c.S)
+	}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if c.A != 1 || c.S != s {
+		t.Errorf("got A=%02X S=%02X, expected S=%02X", c.A, c.S, s)
+	}
+}
+
+func TestRunCycles(t *testing.T) {
+	// LDA #$01 (2); STA $0200,X (5); INC $10 (5); NOP (2); NOP (2)
+	c, _ := newTest(0xa9, 0x01, 0x9d, 0x00, 0x02, 0xe6, 0x10, 0xea, 0xea)
+	steps := 0
+	c.Trace = func(Log) { steps++ }
+	for i, expect := range []struct {
+		n, cycles int
+		pc        uint16
+	}{
+		{1, 2, 0x0602},
+		{4, 5, 0x0605},
+		{8, 7, 0x0608},
+		{8, 2, 0x0609}, // BRK next takes 7
+	} {
+		steps = 0
+		got, err := c.RunCycles(expect.n)
+		if err != nil || got != expect.cycles || c.PC != expect.pc {
+			t.Errorf("%d: got %d cycles to %04X, expected %d to %04X", i, got, c.PC, expect.cycles, expect.pc)
+		}
+		if got > expect.n && steps != 1 {
+			t.Errorf("%d: %d instructions took %d cycles, past %d", i, steps, got, expect.n)
+		}
+	}
+	if c.Cycles != 16 {
+		t.Errorf("got %d total cycles, expected 16", c.Cycles)
+	}
+}
+
+func TestOpLength(t *testing.T) {
+	tests := map[byte]int{
+		0xea: 1, // NOP
+		0x0a: 1, // ASL A
+		0xa9: 2, // LDA #imm
+		0xa5: 2, // LDA zp
+		0xb5: 2, // LDA zp,X
+		0xb6: 2, // LDX zp,Y
+		0xd0: 2, // BNE
+		0xa1: 2, // LDA (zp,X)
+		0xb1: 2, // LDA (zp),Y
+		0x00: 2, // BRK
+		0xad: 3, // LDA abs
+		0xbd: 3, // LDA abs,X
+		0xb9: 3, // LDA abs,Y
+		0x6c: 3, // JMP (ind)
+		0x20: 3, // JSR
+	}
+	for op, n := range tests {
+		if l := Optable[op].Length(); l != n {
+			t.Errorf("%02X %v: got %d, expected %d", op, Optable[op], l, n)
+		}
+	}
+}
+
+func TestStepWith(t *testing.T) {
+	// LDA #$80
+	c, _ := newTest(0xa9, 0x80)
+	called := false
+	err := c.StepWith(func(c *Cpu) {
+		called = true
+		if c.A != 0x80 || !c.N() || c.PC != 0x0602 {
+			t.Errorf("hook saw A=%02X N=%v PC=%04X", c.A, c.N(), c.PC)
+		}
+	})
+	if err != nil || !called {
+		t.Fatalf("got %v, called %v", err, called)
+	}
+}
+
+func TestOpcodeFF(t *testing.T) {
+	if len(Optable) != 0x100 {
+		t.Fatalf("Optable has %d entries", len(Optable))
+	}
+	if o := Optable[0xff]; o == nil || o.String() != "ISC" || o.Mode != MODE_ABSX {
+		t.Fatalf("got %v", Optable[0xff])
+	}
+	// LDX #$01; SEC; ISC $0200,X
+	c, r := newTest(0xa2, 0x01, 0x38, 0xff, 0x00, 0x02)
+	c.AllowIllegal = true
+	r[0x0201] = 0x0f
+	c.A = 0x20
+	for i := 0; i < 3; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r[0x0201] != 0x10 || c.A != 0x10 {
+		t.Errorf("got M=%02X A=%02X, expected 10 and 10", r[0x0201], c.A)
+	}
+}
+
+func TestCyclesPerFrame(t *testing.T) {
+	c, _ := newTest()
+	// 29780.5 and 33247.5 cycles.
+	if n := c.CyclesPerFrame(false); n != 29780 {
+		t.Errorf("NTSC: got %d, expected 29780", n)
+	}
+	if n := c.CyclesPerFrame(true); n != 33247 {
+		t.Errorf("PAL: got %d, expected 33247", n)
+	}
+	// About 60.1 and 50.0 frames a second.
+	if hz := float64(NTSCClock) / float64(c.CyclesPerFrame(false)); hz < 60.09 || hz > 60.11 {
+		t.Errorf("NTSC: %f frames per second", hz)
+	}
+	if hz := float64(PALClock) / float64(c.CyclesPerFrame(true)); hz < 50.00 || hz > 50.01 {
+		t.Errorf("PAL: %f frames per second", hz)
+	}
+}
+
+func TestRunUntilRTS(t *testing.T) {
+	// INC $10; JSR sub; INC $10; RTS; sub: INC $11; RTS
+	c, r := newTest(0xe6, 0x10, 0x20, 0x09, 0x06, 0xe6, 0x10, 0x60, 0x00, 0xe6, 0x11, 0x60)
+	s := c.S
+	if err := c.RunUntilRTS(); err != nil {
+		t.Fatal(err)
+	}
+	if c.PC != 0 || c.S != s || r[0x10] != 2 || r[0x11] != 1 {
+		t.Errorf("got PC=%04X S=%02X, $10=%d $11=%d", c.PC, c.S, r[0x10], r[0x11])
+	}
+}
+
+func TestPeekPoke(t *testing.T) {
+	c, r := newTest()
+	var got [][2]byte
+	c.WatchWrite(0x0200, func(addr uint16, old, new byte) {
+		got = append(got, [2]byte{old, new})
+	})
+	r[0x0200] = 0x11
+	c.Poke(0x0200, 0x22)
+	if c.Peek(0x0200) != 0x22 || r[0x0200] != 0x22 {
+		t.Fatalf("Poke did not write: %02X", r[0x0200])
+	}
+	if len(got) != 1 || got[0] != [2]byte{0x11, 0x22} {
+		t.Errorf("got watches %v, expected one of 11 to 22", got)
+	}
+}
+
+// newLoop returns a Cpu running a loop of common instructions.
+func newLoop() *Cpu {
+	// loop: LDA $0200,X; ADC #$01; STA $0200,X; INX; BNE loop; JMP loop
+	c, _ := newTest(0xbd, 0x00, 0x02, 0x69, 0x01, 0x9d, 0x00, 0x02, 0xe8, 0xd0, 0xf5, 0x4c, 0x00, 0x06)
+	return c
+}
+
+func BenchmarkStep(b *testing.B) {
+	c := newLoop()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Step()
+	}
+}
+
+func TestStepAllocs(t *testing.T) {
+	c := newLoop()
+	if n := testing.AllocsPerRun(1000, func() { c.Step() }); n != 0 {
+		t.Errorf("Step allocates %v times per run", n)
+	}
+}
+
+func TestOpName(t *testing.T) {
+	if s := Optable[0xa9].String(); s != "LDA" {
+		t.Fatalf("got %q, expected LDA", s)
+	}
+	for i, o := range Optable {
+		if o.name == "" {
+			t.Errorf("%02X: no name for %s", i, funcName(o.F))
+		}
+	}
+	// 0xE9 and 0xEB share SBC, but only one is the official opcode.
+	if e9, eb := Optable[0xe9].String(), Optable[0xeb].String(); e9 != "SBC" || eb != "USBC" {
+		t.Errorf("got %s and %s, expected SBC and USBC", e9, eb)
+	}
+	if text, _ := Disassemble([]byte{0xeb, 0x01}, 0); text != "USBC #$01" {
+		t.Errorf("got %q", text)
+	}
+	c, r := newTest()
+	c.AllowIllegal = true
+	if err := c.AssembleAt(0x0600, "SBC #1\nUSBC #1\nNOP #1"); err != nil || !bytes.Equal(r[0x0600:0x0606], []byte{0xe9, 0x01, 0xeb, 0x01, 0x80, 0x01}) {
+		t.Errorf("got % X, %v", r[0x0600:0x0606], err)
+	}
+	if n := testing.AllocsPerRun(100, func() { _ = Optable[0xa9].String() }); n != 0 {
+		t.Errorf("String allocates %v times", n)
+	}
+}
@@ -0,0 +1,264 @@
+package cpu6502
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// mnemonics maps an official instruction name to its opcode in each mode.
+// allMnemonics adds the unofficial names and modes in Unofficial.
+var (
+	mnemonics    = make(map[string]map[Mode]byte)
+	allMnemonics = make(map[string]map[Mode]byte)
+)
+
+func init() {
+	add := func(to map[string]map[Mode]byte, ops []Instruction) {
+		for _, i := range ops {
+			name := i.Name
+			if to[name] == nil {
+				to[name] = make(map[Mode]byte)
+			}
+			i.each(func(m Mode, v byte) {
+				if _, ok := to[name][m]; v != null && !ok {
+					to[name][m] = v
+				}
+			})
+		}
+		// BRK is opcode 0, which the table can't distinguish from null.
+		to["BRK"] = map[Mode]byte{MODE_BRA: 0}
+	}
+	add(mnemonics, Opcodes)
+	add(allMnemonics, Opcodes)
+	add(allMnemonics, Unofficial)
+}
+
+// Assemble assembles src, which is loaded at address 0 unless it starts with
+// an ".org" directive. See AssembleAt for the syntax. Only official opcodes
+// are assembled.
+func Assemble(src string) ([]byte, error) {
+	_, b, err := assemble(0, src, false)
+	return b, err
+}
+
+// AssembleAt assembles src and loads it at addr, or the address of a leading
+// ".org", with LoadProgram. Each line has an optional "label:", a mnemonic,
+// and an operand in the usual syntax: A, #imm, zp, zp,X, zp,Y, abs, abs,X,
+// abs,Y, (ind), (zp,X), or (zp),Y. Numbers are decimal, $hex, or %binary. A
+// label may be used anywhere an address is. ".byte" emits its comma-separated
+// operands. ".org" moves the address of the next line forward, filling the
+// gap with zeros. Comments start with ";". The unofficial opcodes in
+// Unofficial, such as LAX, USBC, and NOP #imm, are assembled only if c has
+// AllowIllegal set.
+func (c *Cpu) AssembleAt(addr uint16, src string) error {
+	org, b, err := assemble(addr, src, c.AllowIllegal)
+	if err != nil {
+		return err
+	}
+	return c.LoadProgram(org, b)
+}
+
+// stmt is one assembled line.
+type stmt struct {
+	line  int
+	pc    uint16
+	name  string
+	mode  Mode
+	arg   string // operand expression
+	bytes []string
+}
+
+// assemble assembles src as if it were loaded at org, returning org as changed
+// by a leading ".org". Unofficial opcodes are used only if illegal is set.
+func assemble(org uint16, src string, illegal bool) (uint16, []byte, error) {
+	names := mnemonics
+	if illegal {
+		names = allMnemonics
+	}
+	labels := make(map[string]uint16)
+	var stmts []stmt
+	pc := int(org)
+	for n, l := range strings.Split(src, "\n") {
+		errorf := func(format string, a ...interface{}) error {
+			return fmt.Errorf("cpu6502: line %d: %s", n+1, fmt.Sprintf(format, a...))
+		}
+		if i := strings.IndexByte(l, ';'); i >= 0 {
+			l = l[:i]
+		}
+		l = strings.TrimSpace(l)
+		if i := strings.IndexByte(l, ':'); i >= 0 {
+			label := strings.TrimSpace(l[:i])
+			if !isIdent(label) {
+				return 0, nil, errorf("bad label %q", label)
+			}
+			if _, ok := labels[label]; ok {
+				return 0, nil, errorf("duplicate label %q", label)
+			}
+			labels[label] = uint16(pc)
+			l = strings.TrimSpace(l[i+1:])
+		}
+		if l == "" {
+			continue
+		}
+		f := strings.Fields(l)
+		s := stmt{
+			line: n + 1,
+			pc:   uint16(pc),
+			name: strings.ToUpper(f[0]),
+		}
+		arg := strings.Join(f[1:], "")
+		if s.name == ".ORG" {
+			n, err := parseNum(arg)
+			switch {
+			case err != nil || n > 0xffff:
+				return 0, nil, errorf("bad .org %q", arg)
+			case len(stmts) == 0:
+				org = uint16(n)
+			case n < pc:
+				return 0, nil, errorf(".org %q moves backwards", arg)
+			}
+			pc = n
+			continue
+		}
+		if s.name == ".BYTE" {
+			s.bytes = strings.Split(arg, ",")
+			pc += len(s.bytes)
+		} else {
+			modes, ok := names[s.name]
+			if !ok {
+				return 0, nil, errorf("unknown mnemonic %q", f[0])
+			}
+			var err error
+			s.mode, s.arg, err = parseMode(modes, arg)
+			if err != nil {
+				return 0, nil, errorf("%s %s: %v", s.name, arg, err)
+			}
+			pc += s.mode.size()
+		}
+		if pc > 0x10000 {
+			return 0, nil, errorf("program passes 0xFFFF")
+		}
+		stmts = append(stmts, s)
+	}
+	var b []byte
+	for _, s := range stmts {
+		errorf := func(format string, a ...interface{}) error {
+			return fmt.Errorf("cpu6502: line %d: %s", s.line, fmt.Sprintf(format, a...))
+		}
+		eval := func(e string, max int) (int, error) {
+			n, err := parseNum(e)
+			if v, ok := labels[e]; ok {
+				n, err = int(v), nil
+			}
+			if err != nil {
+				return 0, errorf("bad operand %q", e)
+			} else if n > max {
+				return 0, errorf("operand %q out of range", e)
+			}
+			return n, nil
+		}
+		for len(b) < int(s.pc-org) {
+			b = append(b, 0)
+		}
+		if s.bytes != nil {
+			for _, e := range s.bytes {
+				v, err := eval(e, 0xff)
+				if err != nil {
+					return 0, nil, err
+				}
+				b = append(b, byte(v))
+			}
+			continue
+		}
+		b = append(b, names[s.name][s.mode])
+		switch s.mode.size() {
+		case 2:
+			branch := s.mode == MODE_BRA && s.name != "BRK"
+			max := 0xff
+			if branch {
+				max = 0xffff
+			}
+			v, err := eval(s.arg, max)
+			if err != nil {
+				return 0, nil, err
+			}
+			if branch {
+				v -= int(s.pc) + 2
+				if v < -128 || v > 127 {
+					return 0, nil, errorf("branch to %q out of range", s.arg)
+				}
+			}
+			b = append(b, byte(v))
+		case 3:
+			v, err := eval(s.arg, 0xffff)
+			if err != nil {
+				return 0, nil, err
+			}
+			b = append(b, byte(v), byte(v>>8))
+		}
+	}
+	return org, b, nil
+}
+
+// parseMode returns the addressing mode of operand arg from those in modes,
+// and the expression for its value.
+func parseMode(modes map[Mode]byte, arg string) (Mode, string, error) {
+	has := func(m Mode) bool {
+		_, ok := modes[m]
+		return ok
+	}
+	// pick chooses between the zero page and absolute forms of a mode.
+	// Labels are always absolute if possible so their size is fixed.
+	pick := func(e string, zp, abs Mode) Mode {
+		if n, err := parseNum(e); (err == nil && n <= 0xff || !has(abs)) && has(zp) {
+			return zp
+		}
+		return abs
+	}
+	upper := strings.ToUpper(arg)
+	var m Mode
+	switch {
+	case arg == "" && has(MODE_BRA) && modes[MODE_BRA] == 0:
+		// BRK, with its padding byte.
+		m, arg = MODE_BRA, "0"
+	case arg == "" && has(MODE_IMP):
+		m = MODE_IMP
+	case arg == "", upper == "A":
+		m, arg = MODE_ACC, ""
+	case strings.HasPrefix(arg, "#"):
+		m, arg = MODE_IMM, arg[1:]
+	case strings.HasPrefix(upper, "(") && strings.HasSuffix(upper, ",X)"):
+		m, arg = MODE_INDX, arg[1:len(arg)-3]
+	case strings.HasPrefix(upper, "(") && strings.HasSuffix(upper, "),Y"):
+		m, arg = MODE_INDY, arg[1:len(arg)-3]
+	case strings.HasPrefix(arg, "(") && strings.HasSuffix(arg, ")"):
+		m, arg = MODE_IND, arg[1:len(arg)-1]
+	case strings.HasSuffix(upper, ",X"):
+		arg = arg[:len(arg)-2]
+		m = pick(arg, MODE_ZPX, MODE_ABSX)
+	case strings.HasSuffix(upper, ",Y"):
+		arg = arg[:len(arg)-2]
+		m = pick(arg, MODE_ZPY, MODE_ABSY)
+	case has(MODE_BRA):
+		m = MODE_BRA
+	default:
+		m = pick(arg, MODE_ZP, MODE_ABS)
+	}
+	if !has(m) {
+		return 0, "", fmt.Errorf("bad addressing mode")
+	}
+	return m, arg, nil
+}
+
+func parseNum(s string) (int, error) {
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "$"):
+		s, base = s[1:], 16
+	case strings.HasPrefix(s, "%"):
+		s, base = s[1:], 2
+	}
+	n, err := strconv.ParseUint(s, base, 32)
+	return int(n), err
+}
@@ -0,0 +1,85 @@
+package cpu6502
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAssembleAt(t *testing.T) {
+	const src = `
+; sum 1 to 10 into $10
+	LDX #10
+	LDA #0
+	CLC
+loop:	STX $10
+	ADC $10
+	DEX
+	BNE loop
+	STA total
+	BRK
+total:	.byte $ff
+`
+	c, r := newTest()
+	if err := c.AssembleAt(0x0600, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if r[0x0611] != 55 {
+		t.Fatalf("got %d, expected 55", r[0x0611])
+	}
+
+	errs := []struct {
+		src, err string
+	}{
+		{"LDA #1\nFOO $10", "line 2: unknown mnemonic"},
+		{"NOP\nNOP\nLDA #$100", "line 3: operand \"$100\" out of range"},
+		{"STA ($1234),Y", "line 1: operand \"$1234\" out of range"},
+		{"LDX $10,X", "line 1: LDX $10,X: bad addressing mode"},
+		{"BNE far\n.byte " + strings.Repeat("0,", 200) + "0\nfar: NOP", "line 1: branch to \"far\" out of range"},
+		// Unofficial opcodes need AllowIllegal.
+		{"NOP #$01", "line 1: NOP #$01: bad addressing mode"},
+		{"NOP $10", "line 1: NOP $10: bad addressing mode"},
+		{"USBC #1", "line 1: unknown mnemonic"},
+	}
+	for _, e := range errs {
+		err := c.AssembleAt(0x0600, e.src)
+		if err == nil || !strings.Contains(err.Error(), e.err) {
+			t.Errorf("%q: got error %v, expected %q", e.src, err, e.err)
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	b, err := Assemble(`
+	.org $0600
+	LDX #$08
+loop:	LDA $0200,X
+	STA ($10),Y
+	DEX
+	BNE loop
+	JMP ($FFFC)
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []byte{
+		0xa2, 0x08,
+		0xbd, 0x00, 0x02,
+		0x91, 0x10,
+		0xca,
+		0xd0, 0xf8,
+		0x6c, 0xfc, 0xff,
+	}
+	if !bytes.Equal(b, expect) {
+		t.Errorf("got % X, expected % X", b, expect)
+	}
+	if b, err := Assemble("NOP\n.org 4\nNOP"); err != nil || !bytes.Equal(b, []byte{0xea, 0, 0, 0, 0xea}) {
+		t.Errorf("got % X, %v", b, err)
+	}
+	if _, err := Assemble("NOP\nNOP\n.org 1"); err == nil || !strings.Contains(err.Error(), "line 3: .org \"1\" moves backwards") {
+		t.Errorf("got %v, expected backwards .org error", err)
+	}
+}
@@ -0,0 +1,166 @@
+package cpu6502
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MemDiff is a contiguous region of memory that differs between two images.
+type MemDiff struct {
+	Addr     uint16
+	Old, New []byte
+}
+
+// DiffMem returns the regions in which before and after differ. Both images
+// start at address 0; bytes past the end of the shorter one are ignored.
+func DiffMem(before, after []byte) []MemDiff {
+	n := len(before)
+	if len(after) < n {
+		n = len(after)
+	}
+	var diffs []MemDiff
+	for i := 0; i < n; i++ {
+		if before[i] == after[i] {
+			continue
+		}
+		j := i + 1
+		for j < n && before[j] != after[j] {
+			j++
+		}
+		diffs = append(diffs, MemDiff{
+			Addr: uint16(i),
+			Old:  append([]byte(nil), before[i:j]...),
+			New:  append([]byte(nil), after[i:j]...),
+		})
+		i = j
+	}
+	return diffs
+}
+
+// OpcodeGrid returns the mnemonic of each opcode, indexed by its high and low
+// nibbles. Unimplemented opcodes are "---".
+func OpcodeGrid() [16][16]string {
+	var g [16][16]string
+	for i, o := range Optable {
+		s := o.String()
+		if o.filler {
+			s = "---"
+		}
+		g[i>>4][i&0xf] = s
+	}
+	return g
+}
+
+// NextPC returns the addresses execution may go to from the instruction at
+// PC, without executing it. For a conditional branch, isBranch is true and
+// taken and notTaken differ. Otherwise both are the single next address.
+func (c *Cpu) NextPC() (taken, notTaken uint16, isBranch bool) {
+	read16 := func(a uint16) uint16 {
+		return uint16(c.M.Read(a)) | uint16(c.M.Read(a+1))<<8
+	}
+	o := c.op(c.M.Read(c.PC))
+	next := c.PC + uint16(o.Length())
+	switch name := o.String(); {
+	case name == "BRK" && c.HaltOnBRK:
+		next = 0
+	case name == "BRK":
+		next = read16(IRQ)
+	case name == "JSR", name == "JMP" && o.Mode == MODE_ABS:
+		next = read16(c.PC + 1)
+	case name == "JMP" && o.Mode == MODE_IND:
+		next = c.indirect(read16(c.PC + 1))
+	case name == "RTS":
+		next = uint16(c.M.Read(0x100|uint16(c.S+1))) | uint16(c.M.Read(0x100|uint16(c.S+2)))<<8 + 1
+	case name == "RTI":
+		next = uint16(c.M.Read(0x100|uint16(c.S+2))) | uint16(c.M.Read(0x100|uint16(c.S+3)))<<8
+	case o.Mode == MODE_BRA:
+		return next + uint16(int8(c.M.Read(c.PC+1))), next, true
+	}
+	return next, next, false
+}
+
+// Disassemble returns the instruction at addr in mem in assembler syntax, and
+// its length in bytes. Bytes past the end of mem read as 0. An unimplemented
+// opcode is shown as a one-byte ".byte" directive. Branches show the address
+// they branch to, as the assembler takes it.
+func Disassemble(mem []byte, addr uint16) (text string, length int) {
+	read := func(i int) byte {
+		if a := int(addr) + i; a < len(mem) {
+			return mem[a]
+		}
+		return 0
+	}
+	o := Optable[read(0)]
+	if o.filler {
+		return fmt.Sprintf(".byte $%02X", read(0)), 1
+	}
+	lo, hi := read(1), read(2)
+	if o.Mode == MODE_BRA && read(0) != 0 {
+		// The operand of BRK, opcode 0, is a padding byte.
+		return fmt.Sprintf("%s $%04X", o, addr+2+uint16(int8(lo))), o.Length()
+	}
+	op8, op16 := uint16(lo), uint16(lo)|uint16(hi)<<8
+	var b byte
+	var v, t uint16
+	switch o.Mode {
+	case MODE_IMM, MODE_BRA:
+		b = lo
+	case MODE_ZP:
+		v = op8
+	case MODE_ABS:
+		v = op16
+	case MODE_ZPX, MODE_ZPY, MODE_INDX, MODE_INDY:
+		t = op8
+	case MODE_ABSX, MODE_ABSY, MODE_IND:
+		t = op16
+	}
+	text = o.String()
+	if m := (Log{O: o, B: b, V: v, T: t}).operand(); m != "" {
+		text += " " + m
+	}
+	return text, o.Length()
+}
+
+// DisassembleRange disassembles the instructions in mem that start at or after
+// start and before end, one line per instruction prefixed with its address.
+func DisassembleRange(mem []byte, start, end uint16) []string {
+	var lines []string
+	for a := int(start); a < int(end); {
+		text, n := Disassemble(mem, uint16(a))
+		lines = append(lines, fmt.Sprintf("%04X  %s", a, text))
+		a += n
+	}
+	return lines
+}
+
+// Hexdump returns the memory from start up to but not including end, 16 bytes
+// a line, in the style of hexdump -C: the address, the bytes in two groups of
+// 8, and the printable ASCII characters.
+func (c *Cpu) Hexdump(start, end uint16) string {
+	var buf bytes.Buffer
+	for a := int(start); a < int(end); a += 16 {
+		n := int(end) - a
+		if n > 16 {
+			n = 16
+		}
+		fmt.Fprintf(&buf, "%04X ", a)
+		ascii := make([]byte, n)
+		for i := 0; i < 16; i++ {
+			if i == 8 {
+				buf.WriteByte(' ')
+			}
+			if i >= n {
+				buf.WriteString("   ")
+				continue
+			}
+			b := c.M.Read(uint16(a + i))
+			fmt.Fprintf(&buf, " %02X", b)
+			if b < 0x20 || b > 0x7e {
+				b = '.'
+			}
+			ascii[i] = b
+		}
+		fmt.Fprintf(&buf, "  |%s|\n", ascii)
+	}
+	return buf.String()
+}
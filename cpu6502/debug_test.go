@@ -0,0 +1,166 @@
+package cpu6502
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffMem(t *testing.T) {
+	// LDX #$00; LDA #$AA; loop: STA $0200,X; INX; CPX #$04; BNE loop
+	c, r := newTest(0xa2, 0x00, 0xa9, 0xaa, 0x9d, 0x00, 0x02, 0xe8, 0xe0, 0x04, 0xd0, 0xf8)
+	before := append(Ram(nil), r...)
+	for c.PC != 0x060c {
+		c.Step()
+	}
+	d := DiffMem(before, r)
+	if len(d) != 1 {
+		t.Fatalf("got %d regions, expected 1: %v", len(d), d)
+	}
+	if d[0].Addr != 0x0200 || string(d[0].Old) != "\x00\x00\x00\x00" || string(d[0].New) != "\xaa\xaa\xaa\xaa" {
+		t.Fatalf("bad region: %+v", d[0])
+	}
+}
+
+func TestOpcodeGrid(t *testing.T) {
+	g := OpcodeGrid()
+	for _, c := range []struct {
+		hi, lo int
+		name   string
+	}{
+		{0xa, 0x9, "LDA"},
+		{0x0, 0x0, "BRK"},
+		{0xe, 0xa, "NOP"},
+		{0x0, 0x2, "---"},
+	} {
+		if s := g[c.hi][c.lo]; s != c.name {
+			t.Errorf("[%X][%X]: got %q, expected %q", c.hi, c.lo, s, c.name)
+		}
+	}
+}
+
+func TestNextPC(t *testing.T) {
+	// LDX #$01; loop: DEX; BNE loop; JSR $0700; BRK
+	c, r := newTest(0xa2, 0x01, 0xca, 0xd0, 0xfd, 0x20, 0x00, 0x07)
+	r[0x0700] = 0x60 // RTS
+	r[IRQ], r[IRQ+1] = 0x00, 0x09
+	r[0x0900] = 0x00 // BRK
+	tests := []struct {
+		taken, notTaken uint16
+		isBranch        bool
+		haltOnBRK       bool
+	}{
+		{0x0602, 0x0602, false, false},
+		{0x0603, 0x0603, false, false},
+		{0x0602, 0x0605, true, false},
+		{0x0700, 0x0700, false, false},
+		{0x0608, 0x0608, false, false},
+		{0x0900, 0x0900, false, false},
+		{0x0000, 0x0000, false, true},
+	}
+	for i, test := range tests {
+		pc := c.PC
+		c.HaltOnBRK = test.haltOnBRK
+		taken, notTaken, isBranch := c.NextPC()
+		if taken != test.taken || notTaken != test.notTaken || isBranch != test.isBranch {
+			t.Errorf("%d: got %04X %04X %v, expected %04X %04X %v", i, taken, notTaken, isBranch, test.taken, test.notTaken, test.isBranch)
+		}
+		if c.PC != pc {
+			t.Fatalf("%d: NextPC moved PC", i)
+		}
+		c.Step()
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		mem    []byte
+		text   string
+		length int
+	}{
+		{[]byte{0xa9, 0x42}, "LDA #$42", 2},
+		{[]byte{0xbd, 0x34, 0x12}, "LDA $1234,X", 3},
+		{[]byte{0x91, 0x10}, "STA ($10),Y", 2},
+		{[]byte{0x0a}, "ASL A", 1},
+		{[]byte{0xe8}, "INX", 1},
+		{[]byte{0x6c, 0xff, 0x30}, "JMP ($30FF)", 3},
+		{[]byte{0xa5}, "LDA $00", 2},
+		{[]byte{0x02}, ".byte $02", 1},
+		{[]byte{0xf0, 0x10}, "BEQ $0112", 2},
+		{[]byte{0xd0, 0xfe}, "BNE $0100", 2},
+		{[]byte{0x10, 0x80}, "BPL $0082", 2},
+		{[]byte{0x00, 0x10}, "BRK $10", 2},
+	}
+	for _, test := range tests {
+		mem := make([]byte, 0x100+len(test.mem))
+		copy(mem[0x100:], test.mem)
+		text, length := Disassemble(mem, 0x100)
+		if text != test.text || length != test.length {
+			t.Errorf("% X: got %q, %d, expected %q, %d", test.mem, text, length, test.text, test.length)
+		}
+	}
+}
+
+func TestDisassembleRange(t *testing.T) {
+	mem := make([]byte, 0x10000)
+	copy(mem[0x600:], []byte{
+		0xa2, 0x05, // LDX #$05
+		0x02,       // unimplemented
+		0xca,       // DEX
+		0xd0, 0xfc, // BNE
+		0x8d, 0x00, 0x02, // STA $0200
+	})
+	got := DisassembleRange(mem, 0x600, 0x609)
+	expect := []string{
+		"0600  LDX #$05",
+		"0602  .byte $02",
+		"0603  DEX",
+		"0604  BNE $0602",
+		"0606  STA $0200",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %q, expected %q", got, expect)
+	}
+}
+
+func TestModeFormat(t *testing.T) {
+	tests := map[Mode]string{
+		MODE_IMM:  "#$12",
+		MODE_ZP:   "$34",
+		MODE_ZPX:  "$56,X",
+		MODE_ZPY:  "$56,Y",
+		MODE_ABS:  "$0034",
+		MODE_ABSX: "$0056,X",
+		MODE_ABSY: "$0056,Y",
+		MODE_IND:  "($0056)",
+		MODE_INDX: "($56,X)",
+		MODE_INDY: "($56),Y",
+		MODE_ACC:  "A",
+		MODE_BRA:  "$12",
+		MODE_IMP:  "",
+	}
+	for m, expect := range tests {
+		got := m.Format()
+		if strings.Contains(got, "%") {
+			got = fmt.Sprintf(got, byte(0x12), uint16(0x34), uint16(0x56))
+		}
+		if got != expect {
+			t.Errorf("%v: got %q, expected %q", m, got, expect)
+		}
+	}
+}
+
+func TestHexdump(t *testing.T) {
+	c, r := newTest()
+	copy(r[0x0200:], "Hello, 6502!\x00\x01\xff\x7fABCD")
+	got := c.Hexdump(0x0200, 0x0214)
+	expect := "0200  48 65 6C 6C 6F 2C 20 36  35 30 32 21 00 01 FF 7F  |Hello, 6502!....|\n" +
+		"0210  41 42 43 44                                       |ABCD|\n"
+	if got != expect {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expect)
+	}
+	if got := c.Hexdump(0x0200, 0x0200); got != "" {
+		t.Errorf("empty range: got %q", got)
+	}
+}
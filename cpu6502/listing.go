@@ -0,0 +1,72 @@
+package cpu6502
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// LoadListing reads an assembler listing from r, writes its bytes into c's
+// memory, and returns the symbols it defines. Each line has the form:
+//
+//	0600: A9 01 8D 00 02 ; comment
+//
+// A comment of the form "name:" defines a label at the line's address. A line
+// with only a label comment labels the next address.
+func LoadListing(c *Cpu, r io.Reader) (map[uint16]string, error) {
+	syms := make(map[uint16]string)
+	var label string
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		code, comment := s.Text(), ""
+		if i := strings.IndexByte(code, ';'); i >= 0 {
+			code, comment = code[:i], strings.TrimSpace(code[i+1:])
+		}
+		if l := strings.TrimSuffix(comment, ":"); l != comment && isIdent(l) {
+			label = l
+		}
+		f := strings.Fields(code)
+		if len(f) == 0 {
+			continue
+		}
+		if !strings.HasSuffix(f[0], ":") {
+			return nil, fmt.Errorf("cpu6502: listing line %d: missing address", n)
+		}
+		a, err := strconv.ParseUint(strings.TrimSuffix(f[0], ":"), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("cpu6502: listing line %d: bad address %q", n, f[0])
+		}
+		if label != "" {
+			syms[uint16(a)] = label
+			label = ""
+		}
+		for _, h := range f[1:] {
+			b, err := strconv.ParseUint(h, 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("cpu6502: listing line %d: bad byte %q", n, h)
+			} else if a > 0xffff {
+				return nil, fmt.Errorf("cpu6502: listing line %d: passes 0xFFFF", n)
+			}
+			c.M.Write(uint16(a), byte(b))
+			a++
+		}
+	}
+	return syms, s.Err()
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
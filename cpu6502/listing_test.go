@@ -0,0 +1,37 @@
+package cpu6502
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadListing(t *testing.T) {
+	const lst = `; test program
+0600: A2 03     ; start:
+0602: CA        ; loop:
+0603: D0 FD     ; until X is 0
+; done:
+0605: 00
+`
+	c, r := newTest()
+	syms, err := LoadListing(c, strings.NewReader(lst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []byte{0xa2, 0x03, 0xca, 0xd0, 0xfd, 0x00}
+	if got := []byte(r[0x0600:0x0606]); string(got) != string(expect) {
+		t.Errorf("got % X, expected % X", got, expect)
+	}
+	if len(syms) != 3 || syms[0x0600] != "start" || syms[0x0602] != "loop" || syms[0x0605] != "done" {
+		t.Errorf("bad symbols: %v", syms)
+	}
+	if _, err := LoadListing(c, strings.NewReader("0600: A9 GG\n")); err == nil {
+		t.Error("expected error")
+	}
+	r[0] = 0
+	if _, err := LoadListing(c, strings.NewReader("FFFF: EA EA\n")); err == nil {
+		t.Error("expected error past 0xFFFF")
+	} else if r[0] != 0 {
+		t.Error("wrapped to 0x0000")
+	}
+}
@@ -0,0 +1,71 @@
+package cpu6502
+
+// EventKind is the kind of an external Event.
+type EventKind int
+
+const (
+	EventIRQ   EventKind = iota // SetIRQLine; Value is 1 if asserted
+	EventNMI                    // TriggerNMI
+	EventWrite                  // Poke of Value to Addr
+)
+
+// Event is an external input to a Cpu at a cycle count.
+type Event struct {
+	Cycle uint64
+	Kind  EventKind
+	Addr  uint16
+	Value byte
+}
+
+// Record starts recording events, discarding any already recorded.
+func (c *Cpu) Record() {
+	c.recording = true
+	c.events = nil
+}
+
+// Events returns the events recorded since Record.
+func (c *Cpu) Events() []Event {
+	return c.events
+}
+
+// Replay arranges for events, in cycle order, to be applied again. Each is
+// applied by the first Step that starts at or after its cycle, which is where
+// it took effect when it was recorded.
+func (c *Cpu) Replay(events []Event) {
+	c.replay = append([]Event(nil), events...)
+}
+
+// Peek returns the byte at addr.
+func (c *Cpu) Peek(addr uint16) byte {
+	return c.M.Read(addr)
+}
+
+// Poke writes b to addr on behalf of an external device, such as a DMA
+// controller, so it can be recorded and watched.
+func (c *Cpu) Poke(addr uint16, b byte) {
+	c.record(Event{Kind: EventWrite, Addr: addr, Value: b})
+	c.store(addr, b)
+}
+
+func (c *Cpu) record(e Event) {
+	if c.recording {
+		e.Cycle = c.Cycles
+		c.events = append(c.events, e)
+	}
+}
+
+// replayEvents applies the replayed events that are due.
+func (c *Cpu) replayEvents() {
+	for len(c.replay) > 0 && c.replay[0].Cycle <= c.Cycles {
+		e := c.replay[0]
+		c.replay = c.replay[1:]
+		switch e.Kind {
+		case EventIRQ:
+			c.SetIRQLine(e.Value != 0)
+		case EventNMI:
+			c.TriggerNMI()
+		case EventWrite:
+			c.Poke(e.Addr, e.Value)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package cpu6502
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	run := func(f func(c *Cpu, step int)) (*Cpu, Ram) {
+		// CLI; loop: INC $10; JMP loop
+		c, r := newTest(0x58, 0xe6, 0x10, 0x4c, 0x01, 0x06)
+		copy(r[0x0700:], []byte{0xa5, 0x10, 0x85, 0x11, 0x40}) // LDA $10; STA $11; RTI
+		r[IRQ+1] = 0x07
+		r[NMI+1] = 0x07
+		for i := 0; i < 40; i++ {
+			f(c, i)
+			c.Step()
+		}
+		return c, r
+	}
+	rec, recRam := run(func(c *Cpu, step int) {
+		switch step {
+		case 0:
+			c.Record()
+		case 7:
+			c.SetIRQLine(true)
+		case 9:
+			c.SetIRQLine(false)
+		case 20:
+			c.Poke(0x12, 0x34)
+		case 25:
+			c.TriggerNMI()
+		}
+	})
+	events := rec.Events()
+	if len(events) != 4 || events[0].Kind != EventIRQ || events[0].Cycle == 0 {
+		t.Fatalf("bad events: %+v", events)
+	}
+	rep, repRam := run(func(c *Cpu, step int) {
+		if step == 0 {
+			c.Replay(events)
+		}
+	})
+	if rep.Register != rec.Register || rep.Cycles != rec.Cycles {
+		t.Fatalf("got %+v after %d cycles, expected %+v after %d", rep.Register, rep.Cycles, rec.Register, rec.Cycles)
+	}
+	if !bytes.Equal(repRam, recRam) {
+		t.Fatal("memory differs")
+	}
+	if recRam[0x11] == 0 || recRam[0x12] != 0x34 {
+		t.Fatal("events had no effect")
+	}
+}
@@ -0,0 +1,86 @@
+package cpu6502
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// stateVersion is the version of the Save format. Bump it, and keep Load
+// reading the old versions, when state changes.
+const stateVersion = 2
+
+var ErrState = errors.New("cpu6502: bad save state")
+
+// state is the Save format, written with encoding/binary in little-endian
+// order after the magic "6502" and a version byte.
+type state struct {
+	A, X, Y, S, P  byte
+	PC             uint16
+	Cycles         uint64
+	IRQ, NMI       bool
+	DisableDecimal bool
+	DisableJMPBug  bool
+	Mem            RAM
+	// DecimalEnabled was added in version 2.
+	DecimalEnabled bool
+}
+
+// Save returns the registers, cycle count, pending interrupts, options, and
+// all 64KB of memory of c. Memory is read through M, so reads with side
+// effects happen. Hooks such as Trace are not saved.
+func (c *Cpu) Save() []byte {
+	s := state{
+		A:              c.A,
+		X:              c.X,
+		Y:              c.Y,
+		S:              c.S,
+		P:              c.P,
+		PC:             c.PC,
+		Cycles:         c.Cycles,
+		IRQ:            c.irq,
+		NMI:            c.nmi,
+		DisableDecimal: c.DisableDecimal,
+		DisableJMPBug:  c.DisableJMPBug,
+		DecimalEnabled: c.DecimalEnabled,
+	}
+	for i := range s.Mem {
+		s.Mem[i] = c.M.Read(uint16(i))
+	}
+	var buf bytes.Buffer
+	buf.WriteString("6502")
+	buf.WriteByte(stateVersion)
+	binary.Write(&buf, binary.LittleEndian, &s)
+	return buf.Bytes()
+}
+
+// Load returns a Cpu restored from b, as returned by Save. Its memory is a
+// new *RAM holding the saved contents.
+func Load(b []byte) (*Cpu, error) {
+	if len(b) < 5 || string(b[:4]) != "6502" {
+		return nil, ErrState
+	}
+	data := b[5:]
+	switch v := b[4]; v {
+	case stateVersion:
+	case 1:
+		// Version 1 ends before DecimalEnabled, which reads as false.
+		data = append(data[:len(data):len(data)], 0)
+	default:
+		return nil, fmt.Errorf("cpu6502: unsupported save state version %d", v)
+	}
+	s := new(state)
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, s); err != nil {
+		return nil, ErrState
+	}
+	c := New(&s.Mem)
+	c.A, c.X, c.Y, c.S, c.P = s.A, s.X, s.Y, s.S, s.P
+	c.PC = s.PC
+	c.Cycles = s.Cycles
+	c.irq, c.nmi = s.IRQ, s.NMI
+	c.DisableDecimal = s.DisableDecimal
+	c.DecimalEnabled = s.DecimalEnabled
+	c.DisableJMPBug = s.DisableJMPBug
+	return c, nil
+}
@@ -0,0 +1,55 @@
+package cpu6502
+
+import "testing"
+
+func TestSaveLoad(t *testing.T) {
+	// loop: LDA $10,X; ADC #$03; STA $10,X; INX; BNE loop
+	c, _ := newTest(0xb5, 0x10, 0x69, 0x03, 0x95, 0x10, 0xe8, 0xd0, 0xf7)
+	c.DecimalEnabled = true
+	for i := 0; i < 100; i++ {
+		c.Step()
+	}
+	c.SetIRQLine(true)
+	c.P |= P_I
+	l, err := Load(c.Save())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.DecimalEnabled || !l.irq {
+		t.Error("options or interrupts not restored")
+	}
+	for i := 0; i < 1000; i++ {
+		c.Step()
+		l.Step()
+		if c.Registers() != l.Registers() {
+			t.Fatalf("step %d: %+v, expected %+v", i, l.Registers(), c.Registers())
+		}
+	}
+	for a := 0; a <= 0xffff; a++ {
+		if c.M.Read(uint16(a)) != l.M.Read(uint16(a)) {
+			t.Fatalf("memory differs at %04X", a)
+		}
+	}
+}
+
+func TestLoadBad(t *testing.T) {
+	c, _ := newTest()
+	b := c.Save()
+	if _, err := Load(b[:100]); err != ErrState {
+		t.Errorf("truncated: got %v", err)
+	}
+	if _, err := Load([]byte("NESM\x1a")); err != ErrState {
+		t.Errorf("bad magic: got %v", err)
+	}
+	// Version 1 has no DecimalEnabled.
+	c.DecimalEnabled = true
+	b = c.Save()
+	b[4] = 1
+	if l, err := Load(b[:len(b)-1]); err != nil || l.DecimalEnabled {
+		t.Errorf("version 1: got %v", err)
+	}
+	b[4] = 99
+	if _, err := Load(b); err == nil {
+		t.Error("expected error for unknown version")
+	}
+}
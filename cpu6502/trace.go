@@ -0,0 +1,52 @@
+package cpu6502
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonLog is the JSON form of a Log.
+type jsonLog struct {
+	PC       uint16 `json:"pc"`
+	Opcode   byte   `json:"opcode"`
+	Mnemonic string `json:"mnemonic"`
+	Mode     string `json:"mode"`
+	Operand  string `json:"operand"`
+	A        byte   `json:"a"`
+	X        byte   `json:"x"`
+	Y        byte   `json:"y"`
+	P        byte   `json:"p"`
+	S        byte   `json:"s"`
+	Cycles   int    `json:"cyc"`
+}
+
+// JSONTrace returns a function for Cpu.Trace that writes each instruction to w
+// as a JSON object on its own line. Registers are those after the
+// instruction ran. Write errors are ignored.
+func JSONTrace(w io.Writer) func(Log) {
+	e := json.NewEncoder(w)
+	return func(l Log) {
+		e.Encode(jsonLog{
+			PC:       l.R.PC,
+			Opcode:   l.I,
+			Mnemonic: l.O.String(),
+			Mode:     l.O.Mode.String(),
+			Operand:  l.operand(),
+			A:        l.R.A,
+			X:        l.R.X,
+			Y:        l.R.Y,
+			P:        l.R.P,
+			S:        l.R.S,
+			Cycles:   l.C,
+		})
+	}
+}
+
+// TextTrace returns a function for Cpu.Trace that writes each instruction to w
+// in the format of Log.String, one per line. Write errors are ignored.
+func TextTrace(w io.Writer) func(Log) {
+	return func(l Log) {
+		fmt.Fprintln(w, l)
+	}
+}
@@ -0,0 +1,128 @@
+package cpu6502
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestJSONTrace(t *testing.T) {
+	// LDA #$10; STA $0200,X
+	c, _ := newTest(0xa9, 0x10, 0x9d, 0x00, 0x02)
+	var buf bytes.Buffer
+	c.Trace = JSONTrace(&buf)
+	c.Step()
+	c.Step()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, expected 2", len(lines))
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"pc", "opcode", "mnemonic", "mode", "operand", "a", "x", "y", "p", "s", "cyc"} {
+		if _, ok := m[k]; !ok {
+			t.Errorf("missing field %q", k)
+		}
+	}
+	var e []jsonLog
+	for _, l := range lines {
+		var j jsonLog
+		if err := json.Unmarshal([]byte(l), &j); err != nil {
+			t.Fatal(err)
+		}
+		e = append(e, j)
+	}
+	if j := e[0]; j.PC != 0x0600 || j.Opcode != 0xa9 || j.Mnemonic != "LDA" || j.Mode != "IMM" || j.Operand != "#$10" || j.A != 0x10 || j.Cycles != 2 {
+		t.Errorf("bad first line: %+v", j)
+	}
+	if j := e[1]; j.PC != 0x0602 || j.Mnemonic != "STA" || j.Mode != "ABSX" || j.Operand != "$0200,X" || j.Cycles != 5 {
+		t.Errorf("bad second line: %+v", j)
+	}
+}
+
+func TestTextTrace(t *testing.T) {
+	// LDA #$10; STA $0200,X
+	c, _ := newTest(0xa9, 0x10, 0x9d, 0x00, 0x02)
+	var buf bytes.Buffer
+	var logs []Log
+	text := TextTrace(&buf)
+	c.Trace = func(l Log) {
+		logs = append(logs, l)
+		text(l)
+	}
+	c.Step()
+	c.Step()
+	expect := fmt.Sprintf("%v\n%v\n", logs[0], logs[1])
+	if got := buf.String(); got != expect {
+		t.Errorf("got %q, expected %q", got, expect)
+	}
+	if !strings.HasPrefix(buf.String(), "0600: A9 LDA #$10") {
+		t.Errorf("unexpected format: %q", buf.String())
+	}
+}
+
+func TestOperandBase(t *testing.T) {
+	// LDA $2000,X; LDA ($10),Y
+	prog := []byte{0xbd, 0x00, 0x20, 0xb1, 0x10}
+	c, r := newTest(prog...)
+	r[0x10], r[0x11] = 0x00, 0x30
+	c.X, c.Y = 5, 7
+	var logs []Log
+	c.Trace = func(l Log) { logs = append(logs, l) }
+	c.Step()
+	c.Step()
+	for i, e := range []struct {
+		operand string
+		v       uint16
+	}{
+		{"$2000,X", 0x2005},
+		{"($10),Y", 0x3007},
+	} {
+		if o := logs[i].operand(); o != e.operand || logs[i].V != e.v {
+			t.Errorf("%d: got %s at %04X, expected %s at %04X", i, o, logs[i].V, e.operand, e.v)
+		}
+	}
+	for i, expect := range []string{"LDA $2000,X", "LDA ($10),Y"} {
+		if text, _ := Disassemble(r, uint16(0x0600+i*3)); text != expect {
+			t.Errorf("got %q, expected %q", text, expect)
+		}
+	}
+}
+
+func TestTraceBuffer(t *testing.T) {
+	// LDX #$00; loop: INX; JMP loop
+	c, _ := newTest(0xa2, 0x00, 0xe8, 0x4c, 0x02, 0x06)
+	c.EnableTraceBuffer(4)
+	c.Step()
+	if l := c.TraceLog(); len(l) != 1 || l[0].R.PC != 0x0600 {
+		t.Fatalf("got %v", l)
+	}
+	if s := c.StringLog(); !strings.Contains(s, "LDX") {
+		t.Fatalf("got %q", s)
+	}
+	for i := 0; i < 9; i++ {
+		c.Step()
+	}
+	l := c.TraceLog()
+	if len(l) != 4 {
+		t.Fatalf("got %d entries, expected 4", len(l))
+	}
+	// LDX, then INX and JMP until the fifth INX; the last 4 remain.
+	for i, e := range []struct {
+		pc uint16
+		x  byte
+	}{{0x0603, 3}, {0x0602, 4}, {0x0603, 4}, {0x0602, 5}} {
+		if l[i].R.PC != e.pc || l[i].R.X != e.x {
+			t.Errorf("%d: got PC=%04X X=%d, expected %04X %d", i, l[i].R.PC, l[i].R.X, e.pc, e.x)
+		}
+	}
+	c.EnableTraceBuffer(0)
+	c.Step()
+	if l := c.TraceLog(); l != nil {
+		t.Errorf("got %d entries after disabling", len(l))
+	}
+}
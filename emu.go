@@ -6,11 +6,46 @@ import (
 	"github.com/mjibson/nsf/cpu6502"
 )
 
+// Region is the television standard of the console being emulated.
+type Region int
+
+const (
+	NTSC Region = iota
+	PAL
+	Dendy
+)
+
+// CPU clock rates, in Hz, of each region.
+const (
+	NTSCClockHz  = cpu6502.NTSCClock
+	PALClockHz   = cpu6502.PALClock
+	DendyClockHz = 1773448
+)
+
 const (
 	// 1.79 MHz
-	cpuClock = 236250000 / 11 / 12
+	cpuClock = NTSCClockHz
 )
 
+// ClockHz returns the CPU clock rate of r.
+func (r Region) ClockHz() uint64 {
+	switch r {
+	case PAL:
+		return PALClockHz
+	case Dendy:
+		return DendyClockHz
+	default:
+		return NTSCClockHz
+	}
+}
+
+// CyclesToDuration returns the time taken by cycles CPU cycles in region.
+func CyclesToDuration(cycles uint64, region Region) time.Duration {
+	hz := region.ClockHz()
+	d := time.Duration(cycles/hz) * time.Second
+	return d + time.Duration(cycles%hz)*time.Second/time.Duration(hz)
+}
+
 var (
 	// DefaultSampleRate is the default sample rate of a track after calling
 	// Init().
@@ -51,11 +86,21 @@ type NSF struct {
 	InitAddr uint16
 	PlayAddr uint16
 
-	SpeedNTSC  uint16
+	SpeedNTSC uint16
+	SpeedPAL  uint16
+	// Region is the region the tune was made for. If DualRegion is set it
+	// also plays correctly in the other of NTSC and PAL.
+	Region     Region
+	DualRegion bool
 	Bankswitch [8]byte
-	Data       []byte
+	// Expansion holds the Expansion* flags of the sound chips used.
+	Expansion byte
+	Data      []byte
 
-	ram         *ram
+	ram *ram
+	// region is the region being played, which sets the clock Tick
+	// counts in and the X given to INIT. Player sets it; Play is NTSC.
+	region      Region
 	totalTicks  int64
 	frameTicks  int64
 	sampleTicks int64
@@ -75,13 +120,16 @@ func (n *NSF) Tick() {
 	n.ram.A.Step()
 	n.totalTicks++
 	n.frameTicks++
-	if n.frameTicks == cpuClock/240 {
+	clock := int64(n.region.ClockHz())
+	if n.frameTicks == clock/240 {
 		n.frameTicks = 0
 		n.ram.A.FrameStep()
 	}
-	n.sampleTicks++
-	if n.SampleRate > 0 && n.sampleTicks >= cpuClock/n.SampleRate {
-		n.sampleTicks = 0
+	// sampleTicks counts in units of 1/clock samples so the rate does
+	// not drift by the remainder of clock/SampleRate.
+	n.sampleTicks += n.SampleRate
+	if n.SampleRate > 0 && n.sampleTicks >= clock {
+		n.sampleTicks -= clock
 		n.append(n.ram.A.Volume())
 	}
 	n.playTicks++
@@ -105,7 +153,8 @@ func (n *NSF) append(v float32) {
 }
 
 // Init initializes the 1-based song for playing. Only one song my play
-// at once. An invalid song index will play the first song.
+// at once. An invalid song index will play the first song. RAM and the APU
+// are cleared as the NSF spec requires; other memory is kept.
 func (n *NSF) Init(song int) {
 	if len(n.Songs) < song || song < 0 {
 		song = 1
@@ -114,28 +163,94 @@ func (n *NSF) Init(song int) {
 	if n.SampleRate == 0 {
 		n.SampleRate = DefaultSampleRate
 	}
-	n.ram = new(ram)
-	copy(n.ram.M[n.LoadAddr:], n.Data)
+	if n.ram == nil {
+		n.ram = new(ram)
+	}
+	n.ram.clear()
+	n.ram.fds = n.Expansion&ExpansionFDS != 0
+	if n.Bankswitch != [8]byte{} {
+		// Banks are 4KB, starting at the 4KB page holding LoadAddr.
+		pad := int(n.LoadAddr & 0xfff)
+		n.ram.banks = append(make([]byte, pad, pad+len(n.Data)), n.Data...)
+		for i, b := range n.Bankswitch {
+			n.ram.Write(0x5ff8+uint16(i), b)
+		}
+	} else {
+		n.ram.banks = nil
+		copy(n.ram.M[n.LoadAddr:], n.Data)
+	}
 	n.Cpu = cpu6502.New(n.ram)
-	n.Cpu.DisableDecimal = true
+	n.Cpu.AllowIllegal = true
 	n.Cpu.P = 0x24
 	n.Cpu.S = 0xfd
 	n.ram.A.Init()
-	n.Cpu.A = byte(song - 1)
-	n.Cpu.PC = n.InitAddr
-	n.Cpu.Run()
+	n.ram.A.dmc.read = n.ram.Read
+	// The new Cpu has no trap set, so any error has stopped INIT early and
+	// PLAY runs as it can.
+	n.CallInit(byte(song - 1))
 	n.Cpu.T = n
 }
 
-func (n *NSF) step() {
-	n.Cpu.Step()
-	if !n.Cpu.I() && n.ram.A.Interrupt {
-		n.Cpu.Interrupt()
+// CallInit runs the INIT routine for the 0-based song until it returns. X is
+// 1 when playing in PAL and 0 otherwise. Unknown opcodes run as NOPs; any other
+// error from the Cpu stops INIT and is returned.
+func (n *NSF) CallInit(song byte) error {
+	n.Cpu.A = song
+	n.Cpu.X = 0
+	if n.region == PAL {
+		n.Cpu.X = 1
+	}
+	n.Cpu.Call(n.InitAddr)
+	for {
+		err := n.Cpu.Run()
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*cpu6502.UnknownOpcodeError); !ok {
+			return err
+		}
 	}
 }
 
+// CallPlay runs the PLAY routine until it returns. As with CallInit, an error
+// other than an unknown opcode stops it and is returned.
+func (n *NSF) CallPlay() error {
+	n.Cpu.Call(n.PlayAddr)
+	for n.Cpu.PC != 0 {
+		if err := n.step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step runs one instruction. Unknown opcodes have run as NOPs and are not
+// errors.
+func (n *NSF) step() error {
+	n.Cpu.SetIRQLine(n.ram.A.Interrupt || n.ram.A.dmc.Interrupt)
+	err := n.Cpu.Step()
+	// Spend the cycles the DMC took for its reads, which may take more.
+	for a := &n.ram.A; a.dmc.Stall > 0; {
+		s := a.dmc.Stall
+		a.dmc.Stall = 0
+		n.Cpu.Tick(s)
+	}
+	if _, ok := err.(*cpu6502.UnknownOpcodeError); ok {
+		return nil
+	}
+	return err
+}
+
+// idle runs a cycle between PLAY calls. The CPU is not running, so DMC reads
+// take no cycles from it.
+func (n *NSF) idle() {
+	n.Tick()
+	n.ram.A.dmc.Stall = 0
+}
+
 // Play returns the requested number of samples. If less are returned,
-// the silence check or time limit have been reached.
+// the silence check or time limit have been reached, or the Cpu stopped with
+// an error.
 func (n *NSF) Play(samples int) []float32 {
 	playDur := time.Duration(n.SpeedNTSC) * time.Nanosecond * 1000
 	sampleDur := time.Duration(samples) * time.Second / time.Duration(n.SampleRate)
@@ -148,12 +263,14 @@ func (n *NSF) Play(samples int) []float32 {
 	n.zero = true
 	for len(n.samples) < samples {
 		n.playTicks = 0
-		n.Cpu.PC = n.PlayAddr
+		n.Cpu.Call(n.PlayAddr)
 		for n.Cpu.PC != 0 && len(n.samples) < samples {
-			n.step()
+			if n.step() != nil {
+				return n.samples
+			}
 		}
 		for i := ticksPerPlay - n.playTicks; i > 0 && len(n.samples) < samples; i-- {
-			n.Tick()
+			n.idle()
 		}
 	}
 	if n.zero {
@@ -183,9 +300,27 @@ func bToString(b []byte) string {
 	return string(b[:i])
 }
 
+// ram is writable everywhere, which also gives FDS tunes the RAM they
+// expect at 0x6000-0xdfff.
 type ram struct {
 	M [0xffff + 1]byte
 	A apu
+	// banks, if non nil, is the bankswitched program data. Writing n to
+	// 0x5ff8+i maps its 4KB bank n into 0x8000+i*0x1000.
+	banks []byte
+	// fds maps RAM at 0x6000-0xdfff instead of ROM at 0x8000-0xdfff.
+	fds bool
+}
+
+// clear zeroes the RAM and APU registers that the NSF spec requires to be
+// clear before each INIT: 0x0000-0x07ff, 0x6000-0x7fff, and 0x4000-0x4017.
+func (r *ram) clear() {
+	for _, b := range [][]byte{r.M[0x0000:0x0800], r.M[0x4000:0x4018], r.M[0x6000:0x8000]} {
+		for i := range b {
+			b[i] = 0
+		}
+	}
+	r.A = apu{}
 }
 
 func (r *ram) Read(v uint16) byte {
@@ -198,8 +333,28 @@ func (r *ram) Read(v uint16) byte {
 }
 
 func (r *ram) Write(v uint16, b byte) {
+	if v >= 0x8000 && (!r.fds || v >= 0xe000) {
+		// ROM
+		return
+	}
 	r.M[v] = b
 	if v&0xf000 == 0x4000 {
 		r.A.Write(v, b)
+	} else if v >= 0x5ff8 && v <= 0x5fff && r.banks != nil {
+		r.bank(int(v-0x5ff8), int(b))
+	}
+}
+
+// bank copies bank n into slot i of 0x8000-0xffff. Past the end of the data
+// reads as 0.
+func (r *ram) bank(i, n int) {
+	const size = 0x1000
+	dst := r.M[0x8000+i*size : 0x8000+(i+1)*size]
+	var src []byte
+	if n*size < len(r.banks) {
+		src = r.banks[n*size:]
+	}
+	for j := copy(dst, src); j < len(dst); j++ {
+		dst[j] = 0
 	}
 }
@@ -0,0 +1,227 @@
+package nsf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mjibson/nsf/cpu6502"
+)
+
+func TestCyclesToDuration(t *testing.T) {
+	// One NTSC frame is 29780.5 cycles.
+	d := CyclesToDuration(29781, NTSC)
+	if d < 16635*time.Microsecond || d > 16645*time.Microsecond {
+		t.Fatalf("got %v, expected ~16.64ms", d)
+	}
+	if d := CyclesToDuration(PALClockHz*3, PAL); d != 3*time.Second {
+		t.Fatalf("got %v, expected 3s", d)
+	}
+}
+
+// testNSF returns an NSF file of one song with data loaded at 0x8000, INIT at
+// 0x8000, and PLAY at 0x8001.
+func testNSF(expansion byte, data ...byte) []byte {
+	b := make([]byte, nsfHEADER_LEN, nsfHEADER_LEN+len(data))
+	copy(b, "NESM\u001a\u0001\u0001\u0001")
+	b[nsfLOAD+1] = 0x80
+	b[nsfINIT+1] = 0x80
+	b[nsfPLAY], b[nsfPLAY+1] = 0x01, 0x80
+	b[nsfSPEED_NTSC], b[nsfSPEED_NTSC+1] = 0x1a, 0x41 // 16666
+	b[nsfEXPANSION] = expansion
+	return append(b, data...)
+}
+
+func TestFDSRAM(t *testing.T) {
+	for _, exp := range []byte{0, ExpansionFDS} {
+		n, err := ReadNSF(testNSF(exp, 0x60, 0x60)) // RTS; RTS
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Init(1)
+		n.ram.Write(0x6000, 0x12)
+		if b := n.ram.Read(0x6000); b != 0x12 {
+			t.Errorf("%02X: 0x6000 not writable", exp)
+		}
+		n.ram.Write(0x8000, 0x34)
+		if b := n.ram.Read(0x8000); (b == 0x34) != (exp == ExpansionFDS) {
+			t.Errorf("%02X: got %02X at 0x8000", exp, b)
+		}
+		n.ram.Write(0xe000, 0x56)
+		if b := n.ram.Read(0xe000); b == 0x56 {
+			t.Errorf("%02X: 0xe000 writable", exp)
+		}
+	}
+}
+
+func TestInitClearsRAM(t *testing.T) {
+	n, err := ReadNSF(testNSF(0, 0x60, 0x60)) // RTS; RTS
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Init(1)
+	cleared := []uint16{0x0000, 0x07ff, 0x4000, 0x4017, 0x6000, 0x7fff}
+	kept := []uint16{0x0800, 0x5000, 0xa000}
+	for _, v := range append(cleared, kept...) {
+		n.ram.M[v] = 0xaa
+	}
+	n.ram.Write(0x4002, 0xff)
+	n.Init(1)
+	for _, v := range cleared {
+		if b := n.ram.M[v]; b != 0 {
+			t.Errorf("%04X: got %02X, expected 0", v, b)
+		}
+	}
+	for _, v := range kept {
+		if b := n.ram.M[v]; b != 0xaa {
+			t.Errorf("%04X: got %02X, expected AA", v, b)
+		}
+	}
+	if n.ram.M[0x8000] != 0x60 {
+		t.Error("program not loaded")
+	}
+	if n.ram.A.S1.timer.length != 0 {
+		t.Error("APU not reset")
+	}
+}
+
+func TestRAMTop(t *testing.T) {
+	r := new(ram)
+	r.M[0xffff] = 0x12
+	if b := r.Read(0xffff); b != 0x12 {
+		t.Fatalf("got %02X at 0xFFFF", b)
+	}
+	r.M[0xfffc], r.M[0xfffd] = 0x00, 0x80
+	c := cpu6502.New(r)
+	c.Reset()
+	if c.PC != 0x8000 {
+		t.Fatalf("got PC=%04X from the reset vector, expected 8000", c.PC)
+	}
+}
+
+func TestBankswitch(t *testing.T) {
+	// Three banks, each filled with its number. Bank 0 holds INIT and PLAY.
+	data := make([]byte, 3*0x1000)
+	for i := range data {
+		data[i] = byte(i / 0x1000)
+	}
+	data[0], data[1] = 0x60, 0x60 // RTS; RTS
+	b := testNSF(0, data...)
+	copy(b[nsfBANKSWITCH:], []byte{0, 1, 2, 0, 0, 0, 0, 0})
+	n, err := ReadNSF(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Init(1)
+	for i, e := range []byte{0, 1, 2, 0} {
+		if b := n.ram.Read(0x8800 + uint16(i)*0x1000); b != e {
+			t.Errorf("slot %d: got %02X, expected %02X", i, b, e)
+		}
+	}
+	n.ram.Write(0x5ff9, 2)
+	if b := n.ram.Read(0x9000); b != 2 {
+		t.Errorf("got %02X after switching, expected 02", b)
+	}
+	n.ram.Write(0x5ff9, 7)
+	if b := n.ram.Read(0x9000); b != 0 {
+		t.Errorf("got %02X from a missing bank, expected 00", b)
+	}
+}
+
+func TestCallInit(t *testing.T) {
+	// INIT: STA $10; STX $11; RTS. PLAY: INC $12; RTS
+	b := testNSF(0, 0x85, 0x10, 0x86, 0x11, 0x60, 0xe6, 0x12, 0x60)
+	b[nsfPLAY] = 0x05
+	n, err := ReadNSF(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.region = PAL
+	n.Init(1)
+	n.Cpu.A = 0xff
+	s := n.Cpu.S
+	if err := n.CallInit(2); err != nil {
+		t.Fatal(err)
+	}
+	if n.Cpu.PC != 0 || n.Cpu.S != s {
+		t.Fatalf("INIT did not return cleanly: PC=%04X S=%02X, expected S=%02X", n.Cpu.PC, n.Cpu.S, s)
+	}
+	if n.ram.M[0x10] != 2 || n.ram.M[0x11] != 1 {
+		t.Errorf("got A=%d X=%d, expected song 2 and PAL", n.ram.M[0x10], n.ram.M[0x11])
+	}
+	n.CallPlay()
+	if err := n.CallPlay(); err != nil {
+		t.Fatal(err)
+	}
+	if n.Cpu.PC != 0 || n.Cpu.S != s || n.ram.M[0x12] != 2 {
+		t.Errorf("PLAY: PC=%04X S=%02X, ran %d times", n.Cpu.PC, n.Cpu.S, n.ram.M[0x12])
+	}
+	n.Cpu.SetTrapPC(0x8002)
+	if err := n.CallInit(0); err != cpu6502.ErrTrap || n.Cpu.PC != 0x8002 {
+		t.Errorf("got %v at %04X, expected a trap at 8002", err, n.Cpu.PC)
+	}
+}
+
+func TestPulseFrequency(t *testing.T) {
+	b := testNSF(0,
+		0xa9, 0x01, 0x8d, 0x15, 0x40, // enable pulse 1
+		0xa9, 0xbf, 0x8d, 0x00, 0x40, // 50% duty, constant volume 15
+		0xa9, 0x08, 0x8d, 0x01, 0x40, // no sweep
+		0xa9, 0xfd, 0x8d, 0x02, 0x40, // period 253:
+		0xa9, 0x00, 0x8d, 0x03, 0x40, // 1789773/16/254 = 440.4Hz
+		0x60, // RTS
+	)
+	b[nsfPLAY] = 0x19
+	n, err := ReadNSF(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Init(1)
+	s := n.Play(int(n.SampleRate))
+	if len(s) != int(n.SampleRate) {
+		t.Fatalf("got %d samples, expected %d", len(s), n.SampleRate)
+	}
+	var lo, hi float32 = 1, 0
+	for _, v := range s {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	mid := (lo + hi) / 2
+	rises := 0
+	for i := 1; i < len(s); i++ {
+		if s[i-1] <= mid && s[i] > mid {
+			rises++
+		}
+	}
+	if rises < 435 || rises > 445 {
+		t.Errorf("got %dHz, expected 440Hz", rises)
+	}
+}
+
+func TestDMCStall(t *testing.T) {
+	b := testNSF(0,
+		0x60,                         // INIT: RTS
+		0xa9, 0x0f, 0x8d, 0x10, 0x40, // PLAY: rate 54 cycles
+		0xa9, 0x00, 0x8d, 0x13, 0x40, // 1 byte
+		0xa9, 0x10, 0x8d, 0x15, 0x40, // start the sample
+		0xea, // NOP
+		0x60, // RTS
+	)
+	n, err := ReadNSF(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Init(1)
+	start := n.Cpu.Cycles
+	n.CallPlay()
+	// 3 LDA/STA pairs 18, NOP 2, RTS 6, and 4 for the DMC read.
+	if c := n.Cpu.Cycles - start; c != 30 {
+		t.Errorf("PLAY took %d cycles, expected 30", c)
+	}
+	if n.ram.A.dmc.Remaining != 0 || n.ram.A.dmc.Stall != 0 {
+		t.Errorf("sample not read: %d left, stall %d", n.ram.A.dmc.Remaining, n.ram.A.dmc.Stall)
+	}
+}
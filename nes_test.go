@@ -30,6 +30,9 @@ func loadNES(fname string) *NSF {
 	}
 	n.ram = new(ram)
 	n.Cpu = cpu6502.New(n.ram)
+	// The 2A03 has no decimal mode, so DecimalEnabled is left off.
+	// nestest exercises the unofficial opcodes.
+	n.Cpu.AllowIllegal = true
 Loop:
 	for a := 0x4000; true; {
 		for i := 0; i < int(prg); i++ {
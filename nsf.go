@@ -26,6 +26,18 @@ const (
 	nsfSPEED_NTSC = 0x6e
 	nsfBANKSWITCH = 0x70
 	nsfSPEED_PAL  = 0x78
+	nsfREGION     = 0x7a
+	nsfEXPANSION  = 0x7b
+)
+
+// Expansion sound chip flags.
+const (
+	ExpansionVRC6 = 1 << iota
+	ExpansionVRC7
+	ExpansionFDS
+	ExpansionMMC5
+	ExpansionN163
+	ExpansionS5B
 )
 
 func New(r io.Reader) (*NSF, error) {
@@ -40,6 +52,21 @@ func New(r io.Reader) (*NSF, error) {
 	return ReadNSFE(b)
 }
 
+// Region flags of the NSF header and NSFE INFO chunk.
+const (
+	regionPAL  = 1 << 0
+	regionDual = 1 << 1
+)
+
+// ParseNSF reads a NSF file from r. Unlike New, it does not accept NSFE.
+func ParseNSF(r io.Reader) (*NSF, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ReadNSF(b)
+}
+
 // ReadNSF reads a NSF file from b.
 func ReadNSF(b []byte) (*NSF, error) {
 	if len(b) < nsfHEADER_LEN || !bytes.HasPrefix(b, []byte("NESM\u001a")) {
@@ -61,10 +88,21 @@ func ReadNSF(b []byte) (*NSF, error) {
 	n.Copyright = bToString(b[nsfCOPYRIGHT:])
 	n.SpeedNTSC = bLEtoUint16(b[nsfSPEED_NTSC:])
 	copy(n.Bankswitch[:], b[nsfBANKSWITCH:nsfSPEED_PAL])
+	n.SpeedPAL = bLEtoUint16(b[nsfSPEED_PAL:])
+	n.setRegion(b[nsfREGION])
+	n.Expansion = b[nsfEXPANSION]
 	n.Data = b[nsfHEADER_LEN:]
 	return &n, nil
 }
 
+func (n *NSF) setRegion(b byte) {
+	n.Region = NTSC
+	if b&regionPAL != 0 {
+		n.Region = PAL
+	}
+	n.DualRegion = b&regionDual != 0
+}
+
 // ReadNSFE reads a NSFE file from b.
 func ReadNSFE(b []byte) (*NSF, error) {
 	if !bytes.HasPrefix(b, []byte("NSFE")) {
@@ -72,6 +110,7 @@ func ReadNSFE(b []byte) (*NSF, error) {
 	}
 	var n NSF
 	n.SpeedNTSC = 16666
+	n.SpeedPAL = 20000
 	b = b[4:]
 	for {
 		if len(b) < 8 {
@@ -96,9 +135,11 @@ func ReadNSFE(b []byte) (*NSF, error) {
 			n.LoadAddr = bLEtoUint16(data)
 			n.InitAddr = bLEtoUint16(data[2:])
 			n.PlayAddr = bLEtoUint16(data[4:])
-			if data[7] != 0 {
+			if data[7]&^ExpansionFDS != 0 {
 				return nil, fmt.Errorf("nsf: unsupported sound chip: %02x", data[7])
 			}
+			n.setRegion(data[6])
+			n.Expansion = data[7]
 			n.Songs = make([]Song, data[8])
 			n.Start = data[9]
 		case "DATA":
@@ -1,6 +1,7 @@
 package nsf
 
 import (
+	"bytes"
 	"os"
 	"testing"
 
@@ -54,3 +55,33 @@ func TestNsfe(t *testing.T) {
 		o.Push(n.Play(ns))
 	}
 }
+
+func TestParseNSF(t *testing.T) {
+	b := testNSF(ExpansionFDS, 0x60, 0x60)
+	copy(b[nsfSONG:], "Game")
+	copy(b[nsfARTIST:], "Artist")
+	copy(b[nsfCOPYRIGHT:], "2014")
+	b[nsfSONGS], b[nsfSTART] = 3, 2
+	b[nsfBANKSWITCH+7] = 5
+	b[nsfREGION] = regionPAL | regionDual
+	n, err := ParseNSF(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.LoadAddr != 0x8000 || n.InitAddr != 0x8000 || n.PlayAddr != 0x8001 {
+		t.Errorf("bad addresses: %04X %04X %04X", n.LoadAddr, n.InitAddr, n.PlayAddr)
+	}
+	if len(n.Songs) != 3 || n.Start != 2 || n.Game != "Game" || n.Artist != "Artist" || n.Copyright != "2014" {
+		t.Errorf("bad info: %d songs, start %d, %q %q %q", len(n.Songs), n.Start, n.Game, n.Artist, n.Copyright)
+	}
+	if n.SpeedNTSC != 16666 || n.Bankswitch != [8]byte{7: 5} || n.Region != PAL || !n.DualRegion || n.Expansion != ExpansionFDS {
+		t.Errorf("bad header: speed %d, banks %v, region %v dual %v, expansion %02X", n.SpeedNTSC, n.Bankswitch, n.Region, n.DualRegion, n.Expansion)
+	}
+	if !bytes.Equal(n.Data, []byte{0x60, 0x60}) {
+		t.Errorf("got data % X", n.Data)
+	}
+	copy(b, "NESN")
+	if _, err := ParseNSF(bytes.NewReader(b)); err != ErrUnrecognized {
+		t.Errorf("bad magic: got %v", err)
+	}
+}
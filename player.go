@@ -0,0 +1,100 @@
+package nsf
+
+// Player plays an NSF one frame at a time.
+type Player struct {
+	*NSF
+	Region Region
+
+	song   int
+	inited bool
+	frames uint64 // frames played since INIT
+	start  int64  // totalTicks at INIT
+	paused bool
+	mid    bool // a Frame was stopped by Pause
+}
+
+// NewPlayer returns a Player of n's starting song in region.
+func NewPlayer(n *NSF, region Region) *Player {
+	return &Player{
+		NSF:    n,
+		Region: region,
+		song:   int(n.Start),
+	}
+}
+
+// SelectSong selects the 1-based song i. Its INIT routine runs at the next
+// Frame.
+func (p *Player) SelectSong(i int) {
+	p.song = i
+	p.inited = false
+}
+
+// Frame runs the INIT routine if the song has not been initialized, then the
+// PLAY routine, and then advances to the end of the frame. It returns the
+// samples generated during the frame, which are valid until the next Frame.
+// If the Cpu stops with an error, the frame ends there and the error is
+// returned; the next Frame calls PLAY again.
+func (p *Player) Frame() ([]float32, error) {
+	if p.paused {
+		return nil, nil
+	}
+	if !p.inited {
+		p.NSF.region = p.Region
+		p.Init(p.song)
+		p.inited = true
+		p.frames = 0
+		p.start = p.totalTicks
+	}
+	p.samples = p.samples[:0]
+	end := p.start + p.frameEnd(p.frames+1)
+	if !p.mid {
+		p.Cpu.Call(p.PlayAddr)
+		p.mid = true
+	}
+	for p.Cpu.PC != 0 && p.totalTicks < end {
+		if p.paused {
+			return p.samples, nil
+		}
+		if err := p.step(); err != nil {
+			p.mid = false
+			p.frames++
+			return p.samples, err
+		}
+	}
+	for p.totalTicks < end {
+		if p.paused {
+			return p.samples, nil
+		}
+		p.idle()
+	}
+	p.mid = false
+	p.frames++
+	return p.samples, nil
+}
+
+// Pause stops a running Frame at the next instruction or idle cycle. While
+// paused, Frame does nothing.
+func (p *Player) Pause() {
+	p.paused = true
+}
+
+// Resume undoes Pause. The next Frame finishes the frame that was stopped,
+// with the cycles it had left.
+func (p *Player) Resume() {
+	p.paused = false
+}
+
+// frameEnd returns the number of cycles in the first n frames.
+func (p *Player) frameEnd(n uint64) int64 {
+	speed := p.SpeedNTSC
+	if p.Region == PAL {
+		speed = p.SpeedPAL
+	}
+	if speed == 0 {
+		speed = 16639
+		if p.Region == PAL {
+			speed = 19997
+		}
+	}
+	return int64(n * uint64(speed) * p.Region.ClockHz() / 1000000)
+}
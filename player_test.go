@@ -0,0 +1,96 @@
+package nsf
+
+import (
+	"testing"
+
+	"github.com/mjibson/nsf/cpu6502"
+)
+
+func TestPlayer(t *testing.T) {
+	// INIT: RTS; PLAY: INC $10; RTS
+	n, err := ReadNSF(testNSF(0, 0x60, 0xe6, 0x10, 0x60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPlayer(n, NTSC)
+	p.SelectSong(1)
+	for i := 0; i < 5; i++ {
+		if s, err := p.Frame(); err != nil {
+			t.Fatal(err)
+		} else if len(s) < 700 || len(s) > 800 {
+			t.Fatalf("got %d samples, expected about 735", len(s))
+		}
+	}
+	if c := p.ram.M[0x10]; c != 5 {
+		t.Fatalf("PLAY ran %d times, expected 5", c)
+	}
+	// 5 frames of 16666us at 1789773Hz.
+	if c := p.totalTicks - p.start; c != 5*16666*NTSCClockHz/1000000 {
+		t.Fatalf("ran %d cycles", c)
+	}
+	p.SelectSong(1)
+	p.Frame()
+	if c := p.ram.M[0x10]; c != 1 {
+		t.Fatalf("got %d after SelectSong, expected 1", c)
+	}
+}
+
+func TestPlayerPAL(t *testing.T) {
+	// INIT: STX $10; RTS; PLAY: RTS
+	b := testNSF(0, 0x86, 0x10, 0x60, 0x60)
+	b[nsfPLAY] = 0x03
+	n, err := ReadNSF(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPlayer(n, PAL)
+	p.SelectSong(1)
+	// 19997us frames at 44100Hz are 881.9 samples.
+	for i := 0; i < 10; i++ {
+		if s, err := p.Frame(); err != nil {
+			t.Fatal(err)
+		} else if len(s) < 881 || len(s) > 882 {
+			t.Fatalf("got %d samples, expected 881 or 882", len(s))
+		}
+	}
+	if x := p.ram.M[0x10]; x != 1 {
+		t.Errorf("INIT got X=%d, expected 1", x)
+	}
+}
+
+func TestPlayerPause(t *testing.T) {
+	// INIT: RTS; PLAY: INC $10; INC $11; RTS
+	n, err := ReadNSF(testNSF(0, 0x60, 0xe6, 0x10, 0xe6, 0x11, 0x60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPlayer(n, NTSC)
+	p.SelectSong(1)
+	p.Frame()
+	// Pause after the first instruction of the next PLAY.
+	pauses := 0
+	p.Cpu.Trace = func(l cpu6502.Log) {
+		if l.R.PC == 0x8001 && pauses == 0 {
+			pauses++
+			p.Pause()
+		}
+	}
+	p.Frame()
+	if p.ram.M[0x10] != 2 || p.ram.M[0x11] != 1 {
+		t.Fatalf("Pause did not stop during PLAY: %d %d", p.ram.M[0x10], p.ram.M[0x11])
+	}
+	if s, _ := p.Frame(); s != nil {
+		t.Fatal("Frame ran while paused")
+	}
+	p.Resume()
+	for i := 0; i < 4; i++ {
+		p.Frame()
+	}
+	// The stopped frame is finished, not restarted: 5 frames in all.
+	if p.ram.M[0x10] != 5 || p.ram.M[0x11] != 5 {
+		t.Fatalf("PLAY ran %d and %d times, expected 5", p.ram.M[0x10], p.ram.M[0x11])
+	}
+	if c := p.totalTicks - p.start; c != p.frameEnd(5) {
+		t.Fatalf("ran %d cycles, expected %d", c, p.frameEnd(5))
+	}
+}